@@ -4,7 +4,7 @@
  */
 
 /**
- * @module k6/Pondigo/nats
+ * @module k6/x/nats
  * @description
  * The xk6-nats project is a k6 extension that enables k6 users to load test NATS using connections, publishers, subscribers, and JetStream functionality.
  * This documentation refers to the development version of xk6-nats project, which means the latest changes and might not be released yet.
@@ -52,6 +52,7 @@ export enum DELIVER_POLICIES {
   DELIVER_POLICY_NEW = "new",
   DELIVER_POLICY_BY_START_SEQUENCE = "by_start_sequence",
   DELIVER_POLICY_BY_START_TIME = "by_start_time",
+  DELIVER_POLICY_LAST_PER_SUBJECT = "last_per_subject",
 }
 
 /* JetStream acknowledgment policies for consumers. */
@@ -76,30 +77,42 @@ export enum SASL_MECHANISMS {
   SASL_TOKEN = "token",
 }
 
-/* Time units for use in timeouts and intervals. */
+/* Time units for use in timeouts and intervals, in milliseconds. */
 export enum TIME {
-  NANOSECOND = 1,
-  MICROSECOND = 1000,
-  MILLISECOND = 1000000,
-  SECOND = 1000000000,
-  MINUTE = 60000000000,
-  HOUR = 3600000000000,
+  MILLISECOND = 1,
+  SECOND = 1000,
+  MINUTE = 60000,
+  HOUR = 3600000,
 }
 
+/**
+ * A duration given in milliseconds or as a string such as "250ms", "1.5s"
+ * or "1m30s".
+ */
+export type Duration = number | string;
+
 /* TLS configurations for creating a secure communication channel with NATS. */
 export interface TLSConfig {
-  /** Enable TLS encryption */
-  enabled: boolean;
   /** Skip TLS certificate verification (insecure) */
-  insecureSkipVerify: boolean;
+  insecure?: boolean;
   /** Minimum TLS version */
-  minVersion: TLS_VERSIONS;
+  minVersion?: TLS_VERSIONS;
+  /** Server name used to verify the server certificate */
+  serverName?: string;
+  /** Start the TLS handshake before the server sends its INFO */
+  handshakeFirst?: boolean;
   /** Path to client certificate file */
-  certFile: string;
+  certFile?: string;
   /** Path to client key file */
-  keyFile: string;
+  keyFile?: string;
   /** Path to CA certificate file */
-  caFile: string;
+  caFile?: string;
+  /** Client certificate as a PEM string, instead of certFile */
+  cert?: string;
+  /** Client key as a PEM string, instead of keyFile */
+  key?: string;
+  /** CA certificates as a PEM string, instead of caFile */
+  ca?: string;
 }
 
 /* SASL configurations for authenticating to NATS. */
@@ -116,24 +129,76 @@ export interface SASLConfig {
 
 /* Connection configurations for connecting to NATS servers. */
 export interface ConnectionConfig {
-  /** List of NATS server URLs */
+  /** List of NATS server URLs: nats:// and tls://, or ws:// and wss:// */
   urls: string[];
-  /** Maximum number of reconnect attempts */
+  /** Negotiate per-message compression on websocket URLs */
+  compression?: boolean;
+  /** Maximum number of reconnect attempts, 60 by default */
   maxReconnects: number;
-  /** Time to wait between reconnect attempts in seconds */
-  reconnectWait: number;
-  /** Ping interval in seconds */
-  pingInterval: number;
+  /** Time to wait between reconnect attempts, 2s by default */
+  reconnectWait: Duration;
+  /** Ping interval, 2m by default */
+  pingInterval: Duration;
   /** Maximum outstanding pings */
   maxPingsOut: number;
-  /** Allow reconnection */
+  /** Allow reconnection, true by default */
   allowReconnect: boolean;
+  /** How to reconnect after a disconnect */
+  reconnectPolicy?: RECONNECT_POLICIES;
+  /** Upper bound of the random delay added to reconnectWait */
+  reconnectJitter?: Duration;
+  /** Same as reconnectJitter, for TLS connections */
+  reconnectJitterTLS?: Duration;
+  /** Time to wait after each failed pass over the servers, replacing reconnectWait */
+  reconnectBackoff?: Duration[];
+  /** Bytes buffered while reconnecting, -1 to fail publishes instead */
+  reconnectBufSize?: number;
+  /** Keep connecting in the background when the first attempt fails */
+  retryOnFailedConnect?: boolean;
+  /** Try the servers in the given order instead of shuffling them */
+  noRandomize?: boolean;
   /** TLS configuration */
   tls: TLSConfig;
   /** SASL configuration */
   sasl: SASLConfig;
-  /** Connection name for identification */
-  name: string;
+  /** Path to a user credentials file with a JWT and an NKey seed */
+  credsFile?: string;
+  /** Contents of a user credentials file, e.g. read with open() */
+  creds?: string;
+  /** User JWT, used together with seed */
+  jwt?: string;
+  /** User NKey seed that signs the server nonce for jwt */
+  seed?: string;
+  /** NKey seed for NKey authentication */
+  nkeySeed?: string;
+  /** Path to a file holding the NKey seed */
+  nkeyFile?: string;
+  /** Connection name for identification, "k6-<scenario>-vu-<id>" by default */
+  name?: string;
+  /** Prefix of the reply subjects used by requests, "_INBOX" by default */
+  inboxPrefix?: string;
+  /** Do not deliver messages published on this connection to its own subscriptions */
+  noEcho?: boolean;
+  /** Fail requests fast when nobody is subscribed, true by default */
+  noResponders?: boolean;
+  /** Keep reconnecting after the same authentication error was seen twice */
+  ignoreAuthErrorAbort?: boolean;
+  /** Time allowed to dial a server and complete the handshake, 2s by default */
+  connectTimeout?: Duration;
+  /** Time allowed for a write to the server before the connection fails */
+  flusherTimeout?: Duration;
+  /** Time allowed for drain to complete, 30s by default */
+  drainTimeout?: Duration;
+  /** Take the connection from a pool keyed by these options */
+  pool?: PoolConfig;
+}
+
+/* Connection pool settings. */
+export interface PoolConfig {
+  /** "vu" shares connections between a VU's iterations, "global" between all VUs; "vu" by default */
+  scope?: "vu" | "global";
+  /** Number of connections handed out round-robin, 1 by default */
+  size?: number;
 }
 
 /* Message format for NATS messages. */
@@ -141,15 +206,101 @@ export interface Message {
   /** Subject the message was published to */
   subject: string;
   /** Message payload data */
-  data: Uint8Array;
+  data: ArrayBuffer;
   /** Reply subject for request/reply pattern */
   reply: string;
-  /** Message headers */
-  headers: Map<string, string>;
-  /** Message timestamp */
-  timestamp: Date;
+  /** Message headers, each mapped to all of its values */
+  headers: Record<string, string[]>;
+
+  /**
+   * @method
+   * Decode the payload as a string.
+   * @returns {string} - Payload text.
+   */
+  text(): string;
+
+  /**
+   * @method
+   * Parse the payload as JSON.
+   * @returns {any} - Parsed payload.
+   */
+  json(): any;
+
+  /**
+   * @method
+   * Publish a reply to the message's reply subject.
+   * @param {string | ArrayBuffer} data - Reply payload.
+   * @param {MessageHeaders} headers - Optional reply headers.
+   * @returns {void} - Nothing.
+   */
+  respond(data: string | ArrayBuffer, headers?: MessageHeaders): void;
+
+  /**
+   * @method
+   * Whether the message was delivered by a JetStream consumer.
+   * @returns {boolean} - True for JetStream messages.
+   */
+  isJetStream(): boolean;
+
+  /**
+   * @method
+   * Acknowledge a JetStream message. Throws on core NATS messages.
+   * @returns {void} - Nothing.
+   */
+  ack(): void;
+
+  /**
+   * @method
+   * Negatively acknowledge a JetStream message so it is redelivered.
+   * @returns {void} - Nothing.
+   */
+  nak(): void;
+
+  /**
+   * @method
+   * Stop redelivery of a JetStream message.
+   * @returns {void} - Nothing.
+   */
+  term(): void;
+
+  /**
+   * @method
+   * Reset the redelivery timer of a JetStream message.
+   * @returns {void} - Nothing.
+   */
+  inProgress(): void;
+
+  /**
+   * @method
+   * Get the JetStream metadata of the message.
+   * @returns {MessageMetadata} - Message metadata.
+   */
+  metadata(): MessageMetadata;
 }
 
+/* JetStream metadata of a message. */
+export interface MessageMetadata {
+  /** Stream name */
+  stream: string;
+  /** Consumer name */
+  consumer: string;
+  /** Sequence of the message in the stream */
+  streamSequence: number;
+  /** Sequence of the delivery for the consumer */
+  consumerSequence: number;
+  /** Number of times the message was delivered */
+  numDelivered: number;
+  /** Number of messages pending for the consumer */
+  numPending: number;
+  /** Time the message was stored, in Unix milliseconds */
+  timestamp: number;
+  /** JetStream domain */
+  domain: string;
+}
+
+/* Headers sent with a message, each name mapped to one or more values. */
+export type MessageHeaders = Record<string, string | string[]>;
+
 /* Configuration for publishing messages. */
 export interface PublishConfig {
   /** Subject to publish to */
@@ -159,7 +310,7 @@ export interface PublishConfig {
   /** Reply subject for request/reply */
   reply: string;
   /** Message headers */
-  headers: Map<string, string>;
+  headers: MessageHeaders;
 }
 
 /* Configuration for subscribing to messages. */
@@ -172,16 +323,56 @@ export interface SubscribeConfig {
   handler: (msg: Message) => void;
 }
 
+/* Options for queueing messages to subscription handlers. */
+export interface HandlerOptions {
+  /** Number of messages buffered for the handler, 1024 by default */
+  queueSize?: number;
+  /** What to do when the queue is full: "drop" (default) or "block" */
+  overflow?: "drop" | "block";
+}
+
 /* Configuration for request/reply pattern. */
 export interface RequestConfig {
   /** Subject to send request to */
   subject: string;
   /** Request payload data */
   data: Uint8Array;
-  /** Timeout in milliseconds */
-  timeout: number;
+  /** Time to wait for the reply */
+  timeout: Duration;
   /** Request headers */
-  headers: Map<string, string>;
+  headers: MessageHeaders;
+}
+
+/* Retries of a failed request. */
+export interface RetryPolicy {
+  /** Attempts including the first one, 1 by default */
+  attempts?: number;
+  /** Wait before the 1st, 2nd, ... retry; the last value repeats */
+  backoff?: Duration[];
+  /** Error codes to retry on, [1006, 1044] (timeouts and no responders) by default */
+  retryOn?: number[];
+}
+
+/* Limits of a requestMany call; collection ends at the first one hit. */
+export interface RequestManyOptions {
+  /** Stop after this many replies, no limit by default */
+  maxMessages?: number;
+  /** Time to collect replies for, 30s by default */
+  maxWait?: Duration;
+  /** Stop when no reply came in for this long after the previous one */
+  stallWait?: Duration;
+  /** Stop at the first reply with an empty payload, which is not returned */
+  sentinel?: boolean;
+}
+
+/* Options of conn.expect. */
+export interface ExpectOptions {
+  /** Whether a message matches; every message does by default */
+  predicate?: (msg: Message) => boolean;
+  /** How long wait() waits, 30s by default */
+  timeout?: Duration;
+  /** Number of matching messages to wait for, 1 by default */
+  count?: number;
 }
 
 /* JetStream stream configuration. */
@@ -196,8 +387,8 @@ export interface StreamConfig {
   maxBytes: number;
   /** Maximum messages in stream */
   maxMsgs: number;
-  /** Maximum age of messages in seconds */
-  maxAge: number;
+  /** Maximum age of messages */
+  maxAge: Duration;
   /** Number of replicas for HA */
   replicas: number;
   /** Discard policy */
@@ -222,12 +413,12 @@ export interface ConsumerConfig {
   optStartTime: number;
   /** Acknowledgment policy */
   ackPolicy: ACK_POLICIES;
-  /** Acknowledgment wait time in seconds */
-  ackWait: number;
+  /** Acknowledgment wait time */
+  ackWait: Duration;
   /** Maximum delivery attempts */
   maxDeliver: number;
-  /** Backoff intervals in seconds */
-  backOff: number[];
+  /** Redelivery backoff intervals */
+  backOff: Duration[];
   /** Subject filter */
   filterSubject: string;
   /** Replay policy */
@@ -240,10 +431,10 @@ export interface ConsumerConfig {
 export interface PullConfig {
   /** Batch size to pull */
   batchSize: number;
-  /** Timeout in milliseconds */
-  timeout: number;
-  /** Maximum wait time in milliseconds */
-  maxWait: number;
+  /** Time to wait for the batch */
+  timeout: Duration;
+  /** Maximum wait time */
+  maxWait: Duration;
 }
 
 /* Configuration for push consumer. */
@@ -258,27 +449,77 @@ export interface PushConfig {
   handler: (msg: Message) => void;
 }
 
+/* Error thrown by the extension and passed to rejected promises. */
+export interface NatsError extends Error {
+  /** Always "NatsError" */
+  name: "NatsError";
+  /** Error code, see the README for the full list */
+  code: number;
+  /** Message of the underlying nats.go error, if any */
+  cause: string | null;
+  /** Whether the operation timed out */
+  isTimeout: boolean;
+  /** Whether a request found no subscribers for its subject */
+  isNoResponders: boolean;
+}
+
+/**
+ * Connect to NATS servers.
+ * @param {ConnectionConfig} connectionConfig - Connection configuration.
+ * @returns {Connection} - Connection instance.
+ */
+export function connect(connectionConfig: ConnectionConfig): Connection;
+
+/**
+ * Create a JetStream context for a connection.
+ * @param {Connection} connection - Connection to use.
+ * @param {JetStreamOptions} options - Optional JetStream options.
+ * @returns {JetStream} - JetStream instance.
+ */
+export function jetStream(
+  connection: Connection,
+  options?: JetStreamOptions,
+): JetStream;
+
+/**
+ * Create a stream configuration.
+ * @param {StreamConfig} streamConfig - Stream configuration options.
+ * @returns {StreamConfig} - Stream configuration.
+ */
+export function streamConfig(streamConfig: StreamConfig): StreamConfig;
+
+/**
+ * Create a consumer configuration.
+ * @param {ConsumerConfig} consumerConfig - Consumer configuration options.
+ * @returns {ConsumerConfig} - Consumer configuration.
+ */
+export function consumerConfig(consumerConfig: ConsumerConfig): ConsumerConfig;
+
+/**
+ * Create TLS options.
+ * @param {TLSConfig} tlsConfig - TLS configuration options.
+ * @returns {TLSConfig} - TLS configuration.
+ */
+export function tlsOptions(tlsConfig: TLSConfig): TLSConfig;
+
 /**
  * @class
  * @classdesc Connection represents a connection to NATS servers.
  * @example
  *
  * ```javascript
- * // In init context
- * const connection = new Connection({
- *   urls: ["nats://localhost:4222"],
- *   maxReconnects: 10,
- *   reconnectWait: 2,
- * });
+ * import { Connection } from "k6/x/nats";
  *
- * // In VU code (default function)
- * connection.publish({
- *   subject: "test.subject",
- *   data: new TextEncoder().encode("Hello NATS!")
- * });
+ * export default function () {
+ *   const connection = new Connection({
+ *     urls: ["nats://localhost:4222"],
+ *     maxReconnects: 10,
+ *     reconnectWait: "2s",
+ *   });
  *
- * // In teardown function
- * connection.close();
+ *   connection.publish("test.subject", "Hello NATS!");
+ *   connection.close();
+ * }
  * ```
  */
 export class Connection {
@@ -293,33 +534,153 @@ export class Connection {
   /**
    * @method
    * Publish a message to a subject.
-   * @param {PublishConfig} publishConfig - Publish configuration.
+   * @param {string} subject - Subject to publish to.
+   * @param {string | ArrayBuffer} data - Message payload.
+   * @param {MessageHeaders} headers - Optional message headers.
    * @returns {void} - Nothing.
    */
-  publish(publishConfig: PublishConfig): void;
+  publish(
+    subject: string,
+    data: string | ArrayBuffer,
+    headers?: MessageHeaders,
+  ): void;
 
   /**
    * @method
    * Subscribe to a subject pattern.
-   * @param {SubscribeConfig} subscribeConfig - Subscribe configuration.
+   * @param {string} subject - Subject pattern to subscribe to.
+   * @param {string} queue - Queue group name, or an empty string.
+   * @param {function} handler - Message handler function, run on the VU's
+   * event loop.
+   * @param {HandlerOptions} options - Handler queue options.
    * @returns {Subscription} - Subscription instance.
    */
-  subscribe(subscribeConfig: SubscribeConfig): Subscription;
+  subscribe(
+    subject: string,
+    queue: string,
+    handler: (msg: Message) => void,
+    options?: HandlerOptions,
+  ): Subscription;
+
+  /**
+   * @method
+   * Subscribe to a subject pattern and read messages with nextMsg instead of
+   * a handler.
+   * @param {string} subject - Subject pattern to subscribe to.
+   * @param {string} queue - Optional queue group name.
+   * @returns {SyncSubscription} - Subscription instance.
+   */
+  subscribeSync(subject: string, queue?: string): SyncSubscription;
+
+  /**
+   * @method
+   * Subscribe to a subject now, to wait for the messages an action causes.
+   * @param {string} subject - Subject pattern to subscribe to.
+   * @param {ExpectOptions} options - Predicate, timeout and count.
+   * @returns {Expectation} - Expectation instance.
+   */
+  expect(subject: string, options?: ExpectOptions): Expectation;
 
   /**
    * @method
    * Send a request and wait for a reply.
-   * @param {RequestConfig} requestConfig - Request configuration.
+   * @param {string} subject - Subject to send the request to.
+   * @param {string | ArrayBuffer} data - Request payload.
+   * @param {Duration} timeout - Timeout of each attempt, 30s by default.
+   * @param {MessageHeaders} headers - Optional request headers.
+   * @param {RetryPolicy} retry - Optional retry policy.
    * @returns {Message} - Reply message.
    */
-  request(requestConfig: RequestConfig): Message;
+  request(
+    subject: string,
+    data: string | ArrayBuffer,
+    timeout?: Duration,
+    headers?: MessageHeaders,
+    retry?: RetryPolicy,
+  ): Message;
+
+  /**
+   * @method
+   * Send a request without blocking the VU.
+   * @param {string} subject - Subject to send the request to.
+   * @param {string | ArrayBuffer} data - Request payload.
+   * @param {Duration} timeout - Timeout of each attempt, 30s by default.
+   * @param {MessageHeaders} headers - Optional request headers.
+   * @param {RetryPolicy} retry - Optional retry policy.
+   * @returns {Promise<Message>} - Resolves with the reply message.
+   */
+  requestAsync(
+    subject: string,
+    data: string | ArrayBuffer,
+    timeout?: Duration,
+    headers?: MessageHeaders,
+    retry?: RetryPolicy,
+  ): Promise<Message>;
+
+  /**
+   * @method
+   * Send a request and collect all replies, e.g. from every instance of a
+   * service.
+   * @param {string} subject - Subject to send the request to.
+   * @param {string | ArrayBuffer} data - Request payload.
+   * @param {RequestManyOptions} options - When to stop collecting replies.
+   * @param {MessageHeaders} headers - Optional request headers.
+   * @returns {Message[]} - Replies in the order received, possibly none.
+   */
+  requestMany(
+    subject: string,
+    data: string | ArrayBuffer,
+    options?: RequestManyOptions,
+    headers?: MessageHeaders,
+  ): Message[];
+
+  /**
+   * @method
+   * Collect the replies to a request without blocking the VU.
+   * @param {string} subject - Subject to send the request to.
+   * @param {string | ArrayBuffer} data - Request payload.
+   * @param {RequestManyOptions} options - When to stop collecting replies.
+   * @param {MessageHeaders} headers - Optional request headers.
+   * @returns {Promise<Message[]>} - Resolves with the replies.
+   */
+  requestManyAsync(
+    subject: string,
+    data: string | ArrayBuffer,
+    options?: RequestManyOptions,
+    headers?: MessageHeaders,
+  ): Promise<Message[]>;
+
+  /**
+   * @method
+   * Flush the connection, waiting for the server to process all pending
+   * messages.
+   * @returns {void} - Nothing.
+   */
+  flush(): void;
+
+  /**
+   * @method
+   * Flush the connection without blocking the VU.
+   * @param {Duration} timeout - Timeout, 30s by default.
+   * @returns {Promise<void>} - Resolves once the flush completed.
+   */
+  flushAsync(timeout?: Duration): Promise<void>;
+
+  /**
+   * @method
+   * Drain all subscriptions and close the connection. Not available on
+   * pooled connections.
+   * @returns {void} - Nothing.
+   */
+  drain(): void;
 
   /**
    * @method
    * Get JetStream context for stream operations.
+   * @param {JetStreamOptions} options - Optional JetStream options.
    * @returns {JetStream} - JetStream instance.
    */
-  jetStream(): JetStream;
+  jetStream(options?: JetStreamOptions): JetStream;
 
   /**
    * @method
@@ -335,9 +696,73 @@ export class Connection {
    */
   stats(): ConnectionStats;
 
+  /**
+   * @method
+   * Describe the server the connection is attached to.
+   * @returns {ServerInfo} - Server information.
+   */
+  serverInfo(): ServerInfo;
+
+  /**
+   * @method
+   * Measure the round trip time to the server.
+   * @returns {number} - Round trip time in milliseconds.
+   */
+  rtt(): number;
+
+  /**
+   * @method
+   * Run handler when the connection to the server is lost.
+   * @param {(event: DisconnectEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onDisconnect(handler: (event: DisconnectEvent) => void): void;
+
+  /**
+   * @method
+   * Run handler once the client is connected again.
+   * @param {(event: ReconnectEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onReconnect(handler: (event: ReconnectEvent) => void): void;
+
+  /**
+   * @method
+   * Run handler once the connection is closed for good.
+   * @param {(event: ConnectionEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onClosed(handler: (event: ConnectionEvent) => void): void;
+
+  /**
+   * @method
+   * Run handler for asynchronous errors such as slow consumers.
+   * @param {(event: AsyncErrorEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onError(handler: (event: AsyncErrorEvent) => void): void;
+
+  /**
+   * @method
+   * Run handler when the server announces it is shutting down.
+   * @param {(event: LameDuckEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onLameDuck(handler: (event: LameDuckEvent) => void): void;
+
+  /**
+   * @method
+   * Run handler when the cluster gossips new servers.
+   * @param {(event: DiscoveredServersEvent) => void} handler - Event handler.
+   * @returns {void} - Nothing.
+   */
+  onDiscoveredServers(handler: (event: DiscoveredServersEvent) => void): void;
+
   /**
    * @destructor
-   * @description Close the connection.
+   * @description Close the connection. On a pooled connection this only
+   * closes the subscriptions made through the handle; the pool closes the
+   * connection at the end of the test.
    * @returns {void} - Nothing.
    */
   close(): void;
@@ -345,15 +770,13 @@ export class Connection {
 
 /**
  * @class
- * @classdesc Subscription represents a subscription to a NATS subject.
+ * @classdesc Subscription represents a subscription to a NATS subject with a
+ * handler.
  * @example
  *
  * ```javascript
- * const subscription = connection.subscribe({
- *   subject: "test.>",
- *   handler: (msg) => {
- *     console.log(`Received: ${new TextDecoder().decode(msg.data)}`);
- *   }
+ * const subscription = connection.subscribe("test.>", "", (msg) => {
+ *   console.log(`Received: ${msg.text()}`);
  * });
  *
  * // Later...
@@ -361,6 +784,11 @@ export class Connection {
  * ```
  */
 export class Subscription {
+  /** Subject pattern of the subscription */
+  subject: string;
+  /** Queue group, or an empty string */
+  queue: string;
+
   /**
    * @method
    * Unsubscribe from the subject.
@@ -368,6 +796,58 @@ export class Subscription {
    */
   unsubscribe(): void;
 
+  /**
+   * @method
+   * Unsubscribe once the handler ran for the messages received so far.
+   * @returns {void} - Nothing.
+   */
+  drain(): void;
+
+  /**
+   * @method
+   * Limit the messages buffered before messages are dropped and a slow
+   * consumer is reported. -1 removes a limit.
+   * @param {number} msgs - Maximum number of messages, 512k by default.
+   * @param {number} bytes - Maximum number of bytes, 64MB by default.
+   * @returns {void} - Nothing.
+   */
+  setPendingLimits(msgs: number, bytes: number): void;
+
+  /**
+   * @method
+   * Count the messages waiting for the handler.
+   * @returns {number} - Number of pending messages.
+   */
+  pending(): number;
+
+  /**
+   * @method
+   * Count the messages received.
+   * @returns {number} - Number of delivered messages.
+   */
+  delivered(): number;
+
+  /**
+   * @method
+   * Count the messages dropped because a buffer was full.
+   * @returns {number} - Number of dropped messages.
+   */
+  dropped(): number;
+
+  /**
+   * @method
+   * Get the highest number of messages buffered at once.
+   * @returns {number} - Number of messages.
+   */
+  maxPending(): number;
+
+  /**
+   * @method
+   * Check whether the subscription is still open.
+   * @returns {boolean} - Subscription status.
+   */
+  isValid(): boolean;
+
   /**
    * @method
    * Get subscription statistics.
@@ -376,6 +856,96 @@ export class Subscription {
   stats(): SubscriptionStats;
 }
 
+/**
+ * @class
+ * @classdesc SyncSubscription is a subscription read from with nextMsg.
+ * @example
+ *
+ * ```javascript
+ * const done = connection.subscribeSync(`jobs.done.${id}`);
+ * connection.publish("jobs", id);
+ * const msg = done.nextMsg("5s");
+ * done.unsubscribe();
+ * ```
+ */
+export class SyncSubscription {
+  /** Subject pattern of the subscription */
+  subject: string;
+  /** Queue group, or an empty string */
+  queue: string;
+
+  /**
+   * @method
+   * Wait for the next message.
+   * @param {Duration} timeout - Timeout, 30s by default.
+   * @returns {Message} - The next message; throws 1006 on timeout.
+   */
+  nextMsg(timeout?: Duration): Message;
+
+  /**
+   * @method
+   * Count the messages received but not read yet.
+   * @returns {number} - Number of pending messages.
+   */
+  pending(): number;
+
+  /**
+   * @method
+   * Unsubscribe from the subject.
+   * @returns {void} - Nothing.
+   */
+  unsubscribe(): void;
+
+  /**
+   * @method
+   * Unsubscribe once max messages were received, counting those received
+   * already.
+   * @param {number} max - Number of messages.
+   * @returns {void} - Nothing.
+   */
+  autoUnsubscribe(max: number): void;
+
+  /**
+   * @method
+   * Check whether the subscription is still open.
+   * @returns {boolean} - Subscription status.
+   */
+  isValid(): boolean;
+}
+
+/**
+ * @class
+ * @classdesc Expectation waits for messages matching a predicate.
+ * @example
+ *
+ * ```javascript
+ * const created = connection.expect("orders.created", {
+ *   predicate: (msg) => msg.json().id === id,
+ *   timeout: "5s",
+ * });
+ * connection.publish("orders.create", JSON.stringify({ id }));
+ * const [msg] = created.wait();
+ * ```
+ */
+export class Expectation {
+  /** Subject pattern of the expectation */
+  subject: string;
+
+  /**
+   * @method
+   * Wait for the expected messages, then unsubscribe.
+   * @returns {Message[]} - The matching messages; throws 1045 on timeout.
+   */
+  wait(): Message[];
+
+  /**
+   * @method
+   * Unsubscribe without waiting.
+   * @returns {void} - Nothing.
+   */
+  cancel(): void;
+}
+
 /**
  * @class
  * @classdesc JetStream provides access to NATS JetStream functionality.
@@ -393,7 +963,7 @@ export class Subscription {
  * });
  *
  * // Publish to stream
- * js.publish("TEST_STREAM", "test.subject", new TextEncoder().encode("Hello JetStream!"));
+ * js.publish("test.subject", "Hello JetStream!");
  * ```
  */
 export class JetStream {
@@ -441,13 +1011,68 @@ export class JetStream {
 
   /**
    * @method
-   * Publish a message to a stream.
-   * @param {string} streamName - Stream name.
+   * Publish a message to a stream and wait for its acknowledgment.
    * @param {string} subject - Subject.
-   * @param {Uint8Array} data - Message data.
-   * @returns {PublishAck} - Publish acknowledgment.
+   * @param {string | ArrayBuffer} data - Message data.
+   * @param {JetStreamPublishOptions} options - Optional publish options.
+   * @returns {PublishAck} - The publish acknowledgment.
    */
-  publish(streamName: string, subject: string, data: Uint8Array): PublishAck;
+  publish(
+    subject: string,
+    data: string | ArrayBuffer,
+    options?: JetStreamPublishOptions,
+  ): PublishAck;
+
+  /**
+   * @method
+   * Publish a message to a stream without blocking the VU.
+   * @param {string} subject - Subject.
+   * @param {string | ArrayBuffer} data - Message data.
+   * @param {JetStreamPublishOptions} options - Optional publish options.
+   * @returns {Promise<PublishAck>} - Resolves with the publish acknowledgment.
+   */
+  publishAsync(
+    subject: string,
+    data: string | ArrayBuffer,
+    options?: JetStreamPublishOptions,
+  ): Promise<PublishAck>;
+
+  /**
+   * @method
+   * Number of async publishes still waiting for their acknowledgment.
+   * @returns {number} - Pending publishes.
+   */
+  publishAsyncPending(): number;
+
+  /**
+   * @method
+   * Wait until every async publish has been acknowledged or has failed.
+   * Throws when the timeout expires first.
+   * @param {Duration} timeout - Timeout, 30s by default.
+   * @returns {void} - Nothing.
+   */
+  publishAsyncComplete(timeout?: Duration): void;
+
+  /**
+   * @method
+   * Get the outcome of the async publishes of this context.
+   * @returns {PublishAsyncStats} - Pending, acknowledged and failed counts.
+   */
+  publishAsyncStats(): PublishAsyncStats;
+
+  /**
+   * @method
+   * Fetch a batch of messages from a pull subscription without blocking the VU.
+   * @param {PullConsumer} sub - Subscription returned by pullSubscribe.
+   * @param {number} batchSize - Maximum number of messages to fetch.
+   * @param {Duration} timeout - Maximum wait, 30s by default.
+   * @returns {Promise<Message[]>} - Resolves with the fetched messages.
+   */
+  fetchAsync(
+    sub: PullConsumer,
+    batchSize: number,
+    timeout?: Duration,
+  ): Promise<Message[]>;
 
   /**
    * @method
@@ -465,11 +1090,21 @@ export class JetStream {
 
   /**
    * @method
-   * Create a push consumer.
-   * @param {PushConfig} pushConfig - Push configuration.
-   * @returns {PushConsumer} - Push consumer instance.
+   * Create a push consumer whose messages are passed to handler.
+   * @param {string} streamName - Stream name.
+   * @param {string} subject - Subject.
+   * @param {string} durable - Durable name, or an empty string.
+   * @param {function} handler - Message handler, run on the VU's event loop.
+   * @param {HandlerOptions} options - Handler queue options.
+   * @returns {Subscription} - Subscription instance.
    */
-  pushSubscribe(pushConfig: PushConfig): PushConsumer;
+  pushSubscribe(
+    streamName: string,
+    subject: string,
+    durable: string,
+    handler: (msg: Message) => void,
+    options?: HandlerOptions,
+  ): Subscription;
 }
 
 /**
@@ -581,10 +1216,26 @@ export interface ConnectionStats {
   bytesReceived: number;
   /** Number of reconnects */
   reconnects: number;
-  /** Number of errors */
-  errors: number;
-  /** Connection uptime in seconds */
-  uptime: number;
+}
+
+/* The server a connection is attached to. */
+export interface ServerInfo {
+  /** Server ID */
+  id: string;
+  /** Server name */
+  name: string;
+  /** Server version */
+  version: string;
+  /** Cluster name, empty outside of a cluster */
+  cluster: string;
+  /** Largest payload the server accepts, in bytes */
+  maxPayload: number;
+  /** Whether the account can use JetStream */
+  jetstream: boolean;
+  /** URL the client is connected to */
+  connectedUrl: string;
+  /** Cluster members learned from the server */
+  discoveredServers: string[];
 }
 
 /* Subscription statistics. */
@@ -597,6 +1248,10 @@ export interface SubscriptionStats {
   pendingMessages: number;
   /** Number of pending bytes */
   pendingBytes: number;
+  /** Number of messages dropped because a buffer was full */
+  dropped: number;
+  /** Highest number of messages buffered at once */
+  maxPending: number;
   /** Subscription active status */
   active: boolean;
 }
@@ -658,6 +1313,79 @@ export interface ConsumerState {
 }
 
 /* Publish acknowledgment. */
+/* Options for a JetStream context. */
+export interface JetStreamOptions {
+  /** Maximum number of async publishes waiting for an ack, 4000 by default */
+  publishAsyncMaxPending?: number;
+}
+
+/* Common fields of connection lifecycle events. */
+export interface ConnectionEvent {
+  /** When the event happened, in Unix milliseconds */
+  time: number;
+}
+
+export interface DisconnectEvent extends ConnectionEvent {
+  /** Server the client was connected to */
+  url: string;
+  /** Why the connection was lost, if known */
+  error: string | null;
+}
+
+export interface ReconnectEvent extends ConnectionEvent {
+  /** Server the client is connected to now */
+  url: string;
+  /** ID of that server */
+  serverId: string;
+  /** Time spent offline, in milliseconds */
+  downtime: number;
+}
+
+export interface AsyncErrorEvent extends ConnectionEvent {
+  /** Error message */
+  error: string;
+  /** Subject of the affected subscription, if any */
+  subject: string | null;
+}
+
+export interface LameDuckEvent extends ConnectionEvent {
+  /** Server that is shutting down */
+  url: string;
+}
+
+export interface DiscoveredServersEvent extends ConnectionEvent {
+  /** URLs of all servers learned from the cluster */
+  servers: string[];
+}
+
+/* Outcome of the async publishes of a JetStream context. */
+export interface PublishAsyncStats {
+  /** Publishes waiting for their ack */
+  pending: number;
+  /** Publishes acknowledged by a stream */
+  acked: number;
+  /** Publishes that failed or timed out */
+  failed: number;
+}
+
+/* Options for publishing to a stream. */
+export interface JetStreamPublishOptions {
+  /** Message ID used by the stream to discard duplicates */
+  msgId?: string;
+  /** Stream that must store the message */
+  expectStream?: string;
+  /** Sequence the stream's last message must have */
+  expectLastSequence?: number;
+  /** Sequence the last message on the same subject must have */
+  expectLastSequencePerSubject?: number;
+  /** Message ID the stream's last message must have */
+  expectLastMsgId?: string;
+  /** Message headers */
+  headers?: MessageHeaders;
+  /** Time to wait for the acknowledgment */
+  timeout?: Duration;
+}
+
 export interface PublishAck {
   /** Stream name */
   stream: string;
@@ -0,0 +1,33 @@
+package nats
+
+import (
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/modules"
+)
+
+// newAsyncPromise runs work in its own goroutine and settles the returned
+// promise on the VU's event loop. work must not touch the JS runtime; the
+// function it returns is called on the event loop to build the resolved
+// value, and may be nil to resolve with undefined.
+func newAsyncPromise(vu modules.VU, work func() (func() any, error)) *goja.Promise {
+	promise, resolve, reject := vu.Runtime().NewPromise()
+	callback := vu.RegisterCallback()
+
+	go func() {
+		result, err := work()
+		callback(func() error {
+			if err != nil {
+				reject(newJSError(vu.Runtime(), err))
+				return nil
+			}
+			if result == nil {
+				resolve(goja.Undefined())
+				return nil
+			}
+			resolve(result())
+			return nil
+		})
+	}()
+
+	return promise
+}
@@ -0,0 +1,69 @@
+package nats
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncOperations(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	_, err = responder.Subscribe("svc.echo", func(msg *nats.Msg) {
+		_ = msg.Respond(msg.Data)
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		(async () => {
+			const conn = nats.connect({ urls: [serverURL] });
+
+			const replies = await Promise.all([
+				conn.requestAsync("svc.echo", "one", "2s"),
+				conn.requestAsync("svc.echo", "two", "2s"),
+			]);
+			if (replies.length !== 2) {
+				throw new Error("expected 2 replies, got " + replies.length);
+			}
+			await conn.flushAsync(0);
+
+			const js = nats.jetStream(conn);
+			js.addStream(nats.streamConfig({
+				name: "ASYNC",
+				subjects: ["async.>"],
+				storage: "memory",
+				replicas: 1,
+			}));
+			const ack = await js.publishAsync("async.one", "payload");
+			if (ack.stream !== "ASYNC" || ack.seq !== 1) {
+				throw new Error("unexpected ack: " + JSON.stringify(ack));
+			}
+
+			const sub = js.pullSubscribe("ASYNC", "async.>", "worker");
+			const msgs = await js.fetchAsync(sub, 10, 1000);
+			if (msgs.length !== 1) {
+				throw new Error("expected 1 fetched message, got " + msgs.length);
+			}
+
+			let rejected = false;
+			try {
+				await conn.requestAsync("", "data", 0);
+			} catch (e) {
+				rejected = true;
+			}
+			if (!rejected) {
+				throw new Error("expected request without subject to reject");
+			}
+
+			conn.close();
+		})();
+	`)
+	require.NoError(t, err)
+}
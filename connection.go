@@ -1,39 +1,185 @@
 package nats
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"math/rand"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/types"
+)
+
+const (
+	// ReconnectPolicyNone never reconnects after a disconnect.
+	ReconnectPolicyNone = "reconnect_policy_none"
+	// ReconnectPolicyReconnect retries up to maxReconnects times.
+	ReconnectPolicyReconnect = "reconnect_policy_reconnect"
+	// ReconnectPolicyForever retries until the connection is closed.
+	ReconnectPolicyForever = "reconnect_policy_reconnect_forever"
 )
 
 type ConnectionOptions struct {
-	URLs           []string    `js:"urls"`
-	MaxReconnects  int         `js:"maxReconnects"`
-	ReconnectWait  int         `js:"reconnectWait"`
-	PingInterval   int         `js:"pingInterval"`
-	MaxPingsOut    int         `js:"maxPingsOut"`
-	AllowReconnect bool        `js:"allowReconnect"`
-	TLS            *TLSOptions `js:"tls"`
-	User           string      `js:"user"`
-	Password       string      `js:"password"`
-	Token          string      `js:"token"`
+	URLs           []string       `js:"urls"`
+	MaxReconnects  int            `js:"maxReconnects"`
+	ReconnectWait  types.Duration `js:"reconnectWait"`
+	PingInterval   types.Duration `js:"pingInterval"`
+	MaxPingsOut    int            `js:"maxPingsOut"`
+	AllowReconnect *bool          `js:"allowReconnect"`
+	TLS            *TLSOptions    `js:"tls"`
+	User           string         `js:"user"`
+	Password       string         `js:"password"`
+	Token          string         `js:"token"`
+
+	// Reconnect behavior. Zero values keep the nats.go defaults.
+	ReconnectPolicy      string           `js:"reconnectPolicy"`
+	ReconnectJitter      types.Duration   `js:"reconnectJitter"`
+	ReconnectJitterTLS   types.Duration   `js:"reconnectJitterTLS"`
+	ReconnectBackoff     []types.Duration `js:"reconnectBackoff"`
+	ReconnectBufSize     int              `js:"reconnectBufSize"` // -1 disables buffering
+	RetryOnFailedConnect bool             `js:"retryOnFailedConnect"`
+	NoRandomize          bool             `js:"noRandomize"`
+
+	// Negotiate per-message compression on ws:// and wss:// URLs
+	Compression bool `js:"compression"`
+
+	// Client identity and protocol behavior. The name defaults to one built
+	// from the VU and scenario, see defaultConnectionName.
+	Name                 string         `js:"name"`
+	InboxPrefix          string         `js:"inboxPrefix"`
+	NoEcho               bool           `js:"noEcho"`
+	NoResponders         *bool          `js:"noResponders"` // true by default
+	IgnoreAuthErrorAbort bool           `js:"ignoreAuthErrorAbort"`
+	ConnectTimeout       types.Duration `js:"connectTimeout"`
+	FlusherTimeout       types.Duration `js:"flusherTimeout"`
+	DrainTimeout         types.Duration `js:"drainTimeout"`
+
+	// Share connections between iterations or VUs, see PoolOptions
+	Pool *PoolOptions `js:"pool"`
+
+	// Decentralized JWT and NKey authentication
+	CredsFile string `js:"credsFile"`
+	Creds     string `js:"creds"`
+	JWT       string `js:"jwt"`
+	Seed      string `js:"seed"`
+	NkeySeed  string `js:"nkeySeed"`
+	NkeyFile  string `js:"nkeyFile"`
 }
 
+// TLSOptions configures TLS. Certificates, keys and CAs are given either as
+// file paths or as inline PEM strings, e.g. read with open() in the init
+// context.
 type TLSOptions struct {
-	CertFile string `js:"certFile"`
-	KeyFile  string `js:"keyFile"`
-	CAFile   string `js:"caFile"`
-	Insecure bool   `js:"insecure"`
+	CertFile       string `js:"certFile"`
+	KeyFile        string `js:"keyFile"`
+	CAFile         string `js:"caFile"`
+	Cert           string `js:"cert"`
+	Key            string `js:"key"`
+	CA             string `js:"ca"`
+	ServerName     string `js:"serverName"`
+	MinVersion     string `js:"minVersion"`
+	HandshakeFirst bool   `js:"handshakeFirst"`
+	Insecure       bool   `js:"insecure"`
+}
+
+// tlsVersions maps the TLS_VERSIONS constants to crypto/tls versions.
+var tlsVersions = map[string]uint16{
+	"tlsv1.0": tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+func newTLSConfig(opts *TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.Insecure,
+		ServerName:         opts.ServerName,
+		MinVersion:         tlsVersions[opts.MinVersion],
+	}
+
+	// Load certificates if provided
+	certPEM, keyPEM := []byte(opts.Cert), []byte(opts.Key)
+	if opts.CertFile != "" {
+		var err error
+		if certPEM, err = os.ReadFile(opts.CertFile); err != nil {
+			return nil, NewConnectionError("failed to read TLS certificate", err)
+		}
+	}
+	if opts.KeyFile != "" {
+		var err error
+		if keyPEM, err = os.ReadFile(opts.KeyFile); err != nil {
+			return nil, NewConnectionError("failed to read TLS key", err)
+		}
+	}
+	if len(certPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, NewConnectionError("failed to load TLS certificate", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// Trust the given CA instead of the system pool
+	caPEM := []byte(opts.CA)
+	if opts.CAFile != "" {
+		var err error
+		if caPEM, err = os.ReadFile(opts.CAFile); err != nil {
+			return nil, NewConnectionError("failed to read TLS CA", err)
+		}
+	}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, NewConnectionError("failed to load TLS CA: no certificates found", nil)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
 }
 
 func (n *NatsInstance) Connect(opts ConnectionOptions) (*Connection, error) {
 	// Validate options
 	if err := ValidateConnectionOptions(opts); err != nil {
+		return nil, NewNatsError(1003, "invalid connection options", err)
+	}
+
+	// Plain numbers used to be seconds, a few milliseconds is most likely a
+	// leftover from that
+	if state := n.vu.State(); state != nil {
+		for name, d := range map[string]types.Duration{
+			"reconnectWait": opts.ReconnectWait,
+			"pingInterval":  opts.PingInterval,
+		} {
+			if d > 0 && time.Duration(d) < 100*time.Millisecond {
+				state.Logger.Warnf("NATS: %s is %s; numbers are milliseconds, use e.g. '2s' for seconds", name, d)
+			}
+		}
+	}
+
+	if opts.Pool != nil {
+		return n.connectPooled(opts)
+	}
+
+	conn, err := n.connect(opts)
+	if err != nil {
 		return nil, err
 	}
+	n.resources.addConn(conn)
+
+	return conn, nil
+}
 
+// connect opens a new connection with validated options. Connect tracks it
+// for the cleanup at the end of the VU, unlike the pools.
+func (n *NatsInstance) connect(opts ConnectionOptions) (*Connection, error) {
 	// Determine URLs
 	urls := opts.URLs
 	if len(urls) == 0 {
@@ -41,24 +187,41 @@ func (n *NatsInstance) Connect(opts ConnectionOptions) (*Connection, error) {
 	}
 
 	// Build NATS options
+	events := newConnEvents(n.vu, n.metrics)
 	natsOpts := []nats.Option{
-		nats.ReconnectWait(time.Duration(opts.ReconnectWait) * time.Second),
-		nats.MaxReconnects(opts.MaxReconnects),
-		nats.PingInterval(time.Duration(opts.PingInterval) * time.Second),
 		nats.MaxPingsOutstanding(opts.MaxPingsOut),
+		nats.ConnectHandler(events.connected),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
 			if err != nil {
 				n.vu.State().Logger.Warnf("NATS disconnected: %v", err)
 			}
+			events.disconnected(nc, err)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			n.vu.State().Logger.Infof("NATS reconnected to %v", nc.ConnectedUrl())
+			events.reconnected(nc)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			n.resources.removeConn(nc)
+			n.resources.closeInvalidSubs()
+			events.closed()
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
 			n.vu.State().Logger.Errorf("NATS error: %v", err)
+			events.asyncError(sub, err)
 		}),
+		nats.LameDuckModeHandler(events.lameDuck),
+		nats.DiscoveredServersHandler(events.discoveredServers),
+	}
+
+	if opts.PingInterval > 0 {
+		natsOpts = append(natsOpts, nats.PingInterval(time.Duration(opts.PingInterval)))
 	}
 
+	natsOpts = append(natsOpts, clientOptions(n.vu, opts)...)
+
+	natsOpts = append(natsOpts, reconnectOptions(opts)...)
+
 	// Add authentication
 	if opts.User != "" && opts.Password != "" {
 		natsOpts = append(natsOpts, nats.UserInfo(opts.User, opts.Password))
@@ -66,55 +229,346 @@ func (n *NatsInstance) Connect(opts ConnectionOptions) (*Connection, error) {
 		natsOpts = append(natsOpts, nats.Token(opts.Token))
 	}
 
+	authOpt, err := jwtAuthOption(opts)
+	if err != nil {
+		return nil, err
+	}
+	if authOpt != nil {
+		natsOpts = append(natsOpts, authOpt)
+	}
+
 	// Add TLS configuration
 	if opts.TLS != nil {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: opts.TLS.Insecure,
-		}
-
-		// Load certificates if provided
-		if opts.TLS.CertFile != "" && opts.TLS.KeyFile != "" {
-			cert, err := tls.LoadX509KeyPair(opts.TLS.CertFile, opts.TLS.KeyFile)
-			if err != nil {
-				return nil, NewConnectionError("failed to load TLS certificate: %v", err)
-			}
-			tlsConfig.Certificates = []tls.Certificate{cert}
+		tlsConfig, err := newTLSConfig(opts.TLS)
+		if err != nil {
+			return nil, err
 		}
 
 		natsOpts = append(natsOpts, nats.Secure(tlsConfig))
+		if opts.TLS.HandshakeFirst {
+			natsOpts = append(natsOpts, nats.TLSHandshakeFirst())
+		}
 	}
 
 	// Connect to NATS
+	events.connectStart = time.Now()
 	nc, err := nats.Connect(strings.Join(urls, ","), natsOpts...)
 	if err != nil {
-		return nil, NewConnectionError("failed to connect to NATS: %v", err)
+		n.metrics.RecordConnectionFailed(strings.Join(urls, ","))
+		return nil, NewConnectionError("failed to connect to NATS", err)
 	}
 
-	// Wait for connection to be established
-	if !nc.IsConnected() {
+	// Wait for connection to be established. With retryOnFailedConnect the
+	// client keeps trying in the background instead.
+	if !nc.IsConnected() && !(opts.RetryOnFailedConnect && nc.IsReconnecting()) {
+		n.metrics.RecordConnectionFailed(strings.Join(urls, ","))
 		return nil, NewConnectionError("NATS connection not established", nil)
 	}
 
+	if nc.IsConnected() {
+		events.connected(nc)
+	}
+
 	return &Connection{
-		vu: n.vu,
-		nc: nc,
+		vu:           n.vu,
+		nc:           nc,
+		metrics:      n.metrics,
+		events:       events,
+		resources:    n.resources,
+		noResponders: opts.NoResponders == nil || *opts.NoResponders,
 	}, nil
 }
 
-func (c *Connection) Close() error {
-	if c.nc != nil {
-		c.nc.Close()
+// clientOptions returns the nats.Options for the client's identity, inbox
+// prefix and timeouts. Zero timeouts keep the nats.go defaults.
+func clientOptions(vu modules.VU, opts ConnectionOptions) []nats.Option {
+	name := opts.Name
+	if name == "" {
+		name = defaultConnectionName(vu)
+	}
+	natsOpts := []nats.Option{nats.Name(name)}
+
+	if opts.InboxPrefix != "" {
+		natsOpts = append(natsOpts, nats.CustomInboxPrefix(opts.InboxPrefix))
+	}
+	if opts.NoEcho {
+		natsOpts = append(natsOpts, nats.NoEcho())
+	}
+	if opts.IgnoreAuthErrorAbort {
+		natsOpts = append(natsOpts, nats.IgnoreAuthErrorAbort())
+	}
+	if opts.ConnectTimeout > 0 {
+		natsOpts = append(natsOpts, nats.Timeout(time.Duration(opts.ConnectTimeout)))
+	}
+	if opts.FlusherTimeout > 0 {
+		natsOpts = append(natsOpts, nats.FlusherTimeout(time.Duration(opts.FlusherTimeout)))
+	}
+	if opts.DrainTimeout > 0 {
+		natsOpts = append(natsOpts, nats.DrainTimeout(time.Duration(opts.DrainTimeout)))
+	}
+	if opts.Compression {
+		natsOpts = append(natsOpts, nats.Compression(true))
+	}
+
+	return natsOpts
+}
+
+// defaultConnectionName identifies the VU and its scenario in the server's
+// monitoring endpoints, e.g. "k6-checkout-vu-12".
+func defaultConnectionName(vu modules.VU) string {
+	state := vu.State()
+	if state == nil {
+		return "k6"
+	}
+
+	name := "k6"
+	if scenario := lib.GetScenarioState(vu.Context()); scenario != nil {
+		name += "-" + scenario.Name
+	}
+	return name + "-vu-" + strconv.FormatUint(state.VUIDGlobal, 10)
+}
+
+func reconnectOptions(opts ConnectionOptions) []nats.Option {
+	if opts.ReconnectPolicy == ReconnectPolicyNone || (opts.AllowReconnect != nil && !*opts.AllowReconnect) {
+		return []nats.Option{nats.NoReconnect()}
+	}
+
+	var natsOpts []nats.Option
+	switch {
+	case opts.ReconnectPolicy == ReconnectPolicyForever:
+		natsOpts = append(natsOpts, nats.MaxReconnects(-1))
+	case opts.MaxReconnects > 0:
+		natsOpts = append(natsOpts, nats.MaxReconnects(opts.MaxReconnects))
+	}
+
+	if opts.ReconnectWait > 0 {
+		natsOpts = append(natsOpts, nats.ReconnectWait(time.Duration(opts.ReconnectWait)))
+	}
+
+	if opts.ReconnectJitter > 0 || opts.ReconnectJitterTLS > 0 {
+		natsOpts = append(natsOpts, nats.ReconnectJitter(reconnectJitter(opts)))
+	}
+
+	if len(opts.ReconnectBackoff) > 0 {
+		natsOpts = append(natsOpts, nats.CustomReconnectDelay(reconnectBackoff(opts)))
+	}
+
+	if opts.ReconnectBufSize != 0 {
+		natsOpts = append(natsOpts, nats.ReconnectBufSize(opts.ReconnectBufSize))
+	}
+
+	if opts.RetryOnFailedConnect {
+		natsOpts = append(natsOpts, nats.RetryOnFailedConnect(true))
+	}
+
+	if opts.NoRandomize {
+		natsOpts = append(natsOpts, nats.DontRandomize())
+	}
+
+	return natsOpts
+}
+
+// reconnectJitter returns the plain and TLS reconnect jitters of opts, with
+// the nats.go defaults for those not given.
+func reconnectJitter(opts ConnectionOptions) (jitter, jitterTLS time.Duration) {
+	jitter, jitterTLS = nats.DefaultReconnectJitter, nats.DefaultReconnectJitterTLS
+	if opts.ReconnectJitter > 0 {
+		jitter = time.Duration(opts.ReconnectJitter)
+	}
+	if opts.ReconnectJitterTLS > 0 {
+		jitterTLS = time.Duration(opts.ReconnectJitterTLS)
+	}
+	return jitter, jitterTLS
+}
+
+// usesTLS reports whether the connection is secured, either by TLS options
+// or by a tls:// or wss:// URL.
+func usesTLS(opts ConnectionOptions) bool {
+	if opts.TLS != nil {
+		return true
+	}
+	for _, raw := range opts.URLs {
+		scheme, _, _ := strings.Cut(strings.ToLower(raw), "://")
+		if scheme == "tls" || scheme == "wss" {
+			return true
+		}
+	}
+	return false
+}
+
+// reconnectBackoff waits reconnectBackoff[n-1] after the n-th failed pass
+// over the server list, repeating the last step once the sequence is used
+// up. nats.go skips its own jitter for custom delays, so it is added here.
+func reconnectBackoff(opts ConnectionOptions) nats.ReconnectDelayHandler {
+	jitter, jitterTLS := reconnectJitter(opts)
+	if usesTLS(opts) {
+		jitter = jitterTLS
+	}
+
+	return func(attempts int) time.Duration {
+		step := min(attempts, len(opts.ReconnectBackoff)) - 1
+		delay := time.Duration(opts.ReconnectBackoff[step])
+		if jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter)))
+		}
+		return delay
+	}
+}
+
+// jwtAuthOption returns the nats.Option for the JWT or NKey credentials in
+// opts, or nil when none are set. ValidateConnectionOptions ensures that at
+// most one kind is given.
+func jwtAuthOption(opts ConnectionOptions) (nats.Option, error) {
+	switch {
+	case opts.CredsFile != "":
+		return nats.UserCredentials(opts.CredsFile), nil
+	case opts.Creds != "":
+		jwt, err := nkeys.ParseDecoratedJWT([]byte(opts.Creds))
+		if err != nil {
+			return nil, NewNatsError(1003, "failed to parse creds", err)
+		}
+		kp, err := nkeys.ParseDecoratedUserNKey([]byte(opts.Creds))
+		if err != nil {
+			return nil, NewNatsError(1003, "failed to parse creds", err)
+		}
+		seed, err := kp.Seed()
+		if err != nil {
+			return nil, NewNatsError(1003, "failed to parse creds", err)
+		}
+		return nats.UserJWTAndSeed(jwt, string(seed)), nil
+	case opts.JWT != "":
+		return nats.UserJWTAndSeed(opts.JWT, opts.Seed), nil
+	case opts.NkeySeed != "":
+		kp, err := nkeys.FromSeed([]byte(opts.NkeySeed))
+		if err != nil {
+			return nil, NewNatsError(1003, "invalid nkeySeed", err)
+		}
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return nil, NewNatsError(1003, "invalid nkeySeed", err)
+		}
+		return nats.Nkey(pub, kp.Sign), nil
+	case opts.NkeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(opts.NkeyFile)
+		if err != nil {
+			return nil, NewNatsError(1003, "failed to load nkeyFile", err)
+		}
+		return opt, nil
 	}
+
+	return nil, nil
+}
+
+// Close closes the connection. On a pooled connection it only closes the
+// subscriptions made through the handle, the pool closes the connection at
+// the end of the test.
+func (c *Connection) Close() (err error) {
+	defer convertError(c.vu, &err)
+
+	if !c.pooled {
+		c.close()
+		return nil
+	}
+
+	c.handleSubs.unsubscribe()
+	// nats.go calls no closed handler for synchronous and pull subscriptions
+	c.resources.closeInvalidSubs()
 	return nil
 }
 
+func (c *Connection) close() {
+	if c.nc != nil && !c.nc.IsClosed() {
+		if c.events != nil {
+			c.events.closing.Store(true)
+		}
+		c.nc.Close()
+		c.metrics.RecordConnectionClosed()
+	}
+}
+
 func (c *Connection) IsConnected() bool {
 	return c.nc != nil && c.nc.IsConnected()
 }
 
-func (c *Connection) Stats() nats.Statistics {
-	if c.nc != nil {
-		return c.nc.Stats()
+// ConnectionStats are the traffic counters of a connection.
+type ConnectionStats struct {
+	MessagesSent     uint64 `js:"messagesSent"`
+	BytesSent        uint64 `js:"bytesSent"`
+	MessagesReceived uint64 `js:"messagesReceived"`
+	BytesReceived    uint64 `js:"bytesReceived"`
+	Reconnects       uint64 `js:"reconnects"`
+}
+
+func (c *Connection) Stats() *ConnectionStats {
+	if c.nc == nil {
+		return &ConnectionStats{}
+	}
+
+	stats := c.nc.Stats()
+	return &ConnectionStats{
+		MessagesSent:     stats.OutMsgs,
+		BytesSent:        stats.OutBytes,
+		MessagesReceived: stats.InMsgs,
+		BytesReceived:    stats.InBytes,
+		Reconnects:       stats.Reconnects,
+	}
+}
+
+// ServerInfo describes the server a connection is attached to.
+type ServerInfo struct {
+	ID                string   `js:"id"`
+	Name              string   `js:"name"`
+	Version           string   `js:"version"`
+	Cluster           string   `js:"cluster"`
+	MaxPayload        int64    `js:"maxPayload"`
+	JetStream         bool     `js:"jetstream"`
+	ConnectedURL      string   `js:"connectedUrl"`
+	DiscoveredServers []string `js:"discoveredServers"`
+}
+
+// ServerInfo returns the server the connection is currently attached to.
+// nats.go does not expose whether the server runs JetStream, so this asks for
+// the account's JetStream info, which also tells whether the account may use
+// it.
+func (c *Connection) ServerInfo() (_ *ServerInfo, err error) {
+	defer convertError(c.vu, &err)
+
+	if c.nc == nil || c.nc.IsClosed() {
+		return nil, ErrConnectionClosed
+	}
+
+	info := &ServerInfo{
+		ID:                c.nc.ConnectedServerId(),
+		Name:              c.nc.ConnectedServerName(),
+		Version:           c.nc.ConnectedServerVersion(),
+		Cluster:           c.nc.ConnectedClusterName(),
+		MaxPayload:        c.nc.MaxPayload(),
+		ConnectedURL:      c.nc.ConnectedUrl(),
+		DiscoveredServers: c.nc.DiscoveredServers(),
+	}
+
+	if js, err := c.nc.JetStream(); err == nil {
+		ctx, cancel := context.WithTimeout(c.vu.Context(), 5*time.Second)
+		defer cancel()
+		_, err = js.AccountInfo(nats.Context(ctx))
+		info.JetStream = err == nil
+	}
+
+	return info, nil
+}
+
+// Rtt measures the round trip time to the server in milliseconds. It is not
+// named RTT, which k6 would expose as rTT.
+func (c *Connection) Rtt() (_ float64, err error) {
+	defer convertError(c.vu, &err)
+
+	if c.nc == nil || c.nc.IsClosed() {
+		return 0, ErrConnectionClosed
+	}
+
+	rtt, err := c.nc.RTT()
+	if err != nil {
+		return 0, NewNatsError(1042, "failed to measure round trip time", err)
 	}
-	return nats.Statistics{}
+	return float64(rtt) / float64(time.Millisecond), nil
 }
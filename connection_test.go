@@ -0,0 +1,684 @@
+package nats
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/lib/types"
+)
+
+// generateTestCA creates a CA and a server certificate for 127.0.0.1 and the
+// name "nats.test", returning the CA PEM and the server TLS certificate.
+func generateTestCA(t *testing.T) ([]byte, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "xk6-nats test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "nats.test"},
+		DNSNames:     []string{"nats.test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caTemplate, &serverKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), tls.Certificate{
+		Certificate: [][]byte{serverDER},
+		PrivateKey:  serverKey,
+	}
+}
+
+// runTLSTestServer starts an embedded NATS server that requires TLS.
+func runTLSTestServer(t *testing.T, cert tls.Certificate, handshakeFirst bool) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   -1,
+		NoLog:  true,
+		NoSigs: true,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+		TLSTimeout:        2,
+		TLSHandshakeFirst: handshakeFirst,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s
+}
+
+func TestConnectTLS(t *testing.T) {
+	caPEM, cert := generateTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	s := runTLSTestServer(t, cert, false)
+	first := runTLSTestServer(t, cert, true)
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("handshakeFirstURL", first.ClientURL()))
+	require.NoError(t, runtime.Set("caFile", caFile))
+	require.NoError(t, runtime.Set("caPEM", string(caPEM)))
+
+	_, err := runtime.RunString(`
+		const fromFile = nats.connect({ urls: [serverURL], tls: { caFile: caFile } });
+		fromFile.close();
+
+		const inline = nats.connect({
+			urls: [serverURL],
+			tls: { ca: caPEM, serverName: "nats.test", minVersion: "tlsv1.3" },
+		});
+		inline.close();
+
+		const handshakeFirst = nats.connect({
+			urls: [handshakeFirstURL],
+			tls: { ca: caPEM, handshakeFirst: true },
+		});
+		handshakeFirst.close();
+
+		let untrusted;
+		try {
+			nats.connect({ urls: [serverURL], tls: {}, maxReconnects: 0 });
+		} catch (e) {
+			untrusted = e.code;
+		}
+
+		let wrongName;
+		try {
+			nats.connect({ urls: [serverURL], tls: { ca: caPEM, serverName: "other.test" }, maxReconnects: 0 });
+		} catch (e) {
+			wrongName = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1002), runtime.Get("untrusted").ToInteger())
+	assert.Equal(t, int64(1002), runtime.Get("wrongName").ToInteger())
+}
+
+// runOperatorTestServer starts an embedded NATS server in operator mode that
+// trusts a single account, and returns it with the JWT and seed of a user of
+// that account.
+func runOperatorTestServer(t *testing.T) (*server.Server, string, string) {
+	t.Helper()
+
+	operatorKP, err := nkeys.CreateOperator()
+	require.NoError(t, err)
+	operatorPub, err := operatorKP.PublicKey()
+	require.NoError(t, err)
+	operatorClaims := jwt.NewOperatorClaims(operatorPub)
+	_, err = operatorClaims.Encode(operatorKP)
+	require.NoError(t, err)
+
+	accountKP, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	accountPub, err := accountKP.PublicKey()
+	require.NoError(t, err)
+	accountJWT, err := jwt.NewAccountClaims(accountPub).Encode(operatorKP)
+	require.NoError(t, err)
+
+	userKP, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userPub, err := userKP.PublicKey()
+	require.NoError(t, err)
+	userSeed, err := userKP.Seed()
+	require.NoError(t, err)
+	userJWT, err := jwt.NewUserClaims(userPub).Encode(accountKP)
+	require.NoError(t, err)
+
+	resolver := &server.MemAccResolver{}
+	require.NoError(t, resolver.Store(accountPub, accountJWT))
+
+	s, err := server.NewServer(&server.Options{
+		Host:             "127.0.0.1",
+		Port:             -1,
+		NoLog:            true,
+		NoSigs:           true,
+		TrustedOperators: []*jwt.OperatorClaims{operatorClaims},
+		AccountResolver:  resolver,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s, userJWT, string(userSeed)
+}
+
+func TestConnectJWTAuth(t *testing.T) {
+	s, userJWT, userSeed := runOperatorTestServer(t)
+
+	creds, err := jwt.FormatUserConfig(userJWT, []byte(userSeed))
+	require.NoError(t, err)
+	credsFile := filepath.Join(t.TempDir(), "user.creds")
+	require.NoError(t, os.WriteFile(credsFile, creds, 0o600))
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("credsFile", credsFile))
+	require.NoError(t, runtime.Set("creds", string(creds)))
+	require.NoError(t, runtime.Set("userJWT", userJWT))
+	require.NoError(t, runtime.Set("userSeed", userSeed))
+
+	_, err = runtime.RunString(`
+		for (const auth of [{ credsFile }, { creds }, { jwt: userJWT, seed: userSeed }]) {
+			const conn = nats.connect(Object.assign({ urls: [serverURL] }, auth));
+			conn.publish("auth.check", "ok");
+			conn.flush();
+			conn.close();
+		}
+
+		let anonymous;
+		try {
+			nats.connect({ urls: [serverURL], maxReconnects: 0 });
+		} catch (e) {
+			anonymous = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1002), runtime.Get("anonymous").ToInteger())
+}
+
+func TestConnectNkeyAuth(t *testing.T) {
+	userKP, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userPub, err := userKP.PublicKey()
+	require.NoError(t, err)
+	userSeed, err := userKP.Seed()
+	require.NoError(t, err)
+	seedFile := filepath.Join(t.TempDir(), "user.nk")
+	require.NoError(t, os.WriteFile(seedFile, userSeed, 0o600))
+
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   -1,
+		NoLog:  true,
+		NoSigs: true,
+		Nkeys:  []*server.NkeyUser{{Nkey: userPub}},
+	})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("seedFile", seedFile))
+	require.NoError(t, runtime.Set("userSeed", string(userSeed)))
+
+	_, err = runtime.RunString(`
+		nats.connect({ urls: [serverURL], nkeySeed: userSeed }).close();
+		nats.connect({ urls: [serverURL], nkeyFile: seedFile }).close();
+
+		let invalid;
+		try {
+			nats.connect({ urls: [serverURL], nkeySeed: "not-a-seed" });
+		} catch (e) {
+			invalid = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1003), runtime.Get("invalid").ToInteger())
+}
+
+// runTestServerOnPort starts a plain embedded NATS server on port.
+func runTestServerOnPort(t *testing.T, port int) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   port,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestConnectReconnectPolicies(t *testing.T) {
+	port := freePort(t)
+	s := runTestServerOnPort(t, port)
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	laterPort := freePort(t)
+	require.NoError(t, runtime.Set("laterURL", "nats://127.0.0.1:"+strconv.Itoa(laterPort)))
+
+	_, err := runtime.RunString(`
+		var none = nats.connect({ urls: [serverURL], reconnectPolicy: nats.RECONNECT_POLICIES.RECONNECT_POLICY_NONE });
+		var disabled = nats.connect({ urls: [serverURL], allowReconnect: false });
+		var forever = nats.connect({
+			urls: [serverURL],
+			reconnectPolicy: nats.RECONNECT_POLICIES.RECONNECT_POLICY_RECONNECT_FOREVER,
+			reconnectBackoff: ["50ms"],
+			reconnectBufSize: -1,
+			noRandomize: true,
+		});
+		var retrying = nats.connect({
+			urls: [laterURL],
+			retryOnFailedConnect: true,
+			reconnectPolicy: nats.RECONNECT_POLICIES.RECONNECT_POLICY_RECONNECT_FOREVER,
+			reconnectBackoff: [50],
+		});
+	`)
+	require.NoError(t, err)
+
+	conn := func(name string) *Connection {
+		return runtime.Get(name).Export().(*Connection)
+	}
+	assert.False(t, conn("retrying").IsConnected())
+
+	s.Shutdown()
+	runTestServerOnPort(t, port)
+	runTestServerOnPort(t, laterPort)
+
+	assert.Eventually(t, conn("forever").IsConnected, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, conn("retrying").IsConnected, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, conn("none").nc.IsClosed())
+	assert.True(t, conn("disabled").nc.IsClosed())
+
+	for _, name := range []string{"none", "disabled", "forever", "retrying"} {
+		require.NoError(t, conn(name).Close())
+	}
+}
+
+func TestConnectionEvents(t *testing.T) {
+	port := freePort(t)
+	opts := &server.Options{
+		Host:   "127.0.0.1",
+		Port:   port,
+		NoLog:  true,
+		NoSigs: true,
+		Users: []*server.User{{
+			Username: "app",
+			Password: "secret",
+			Permissions: &server.Permissions{
+				Publish: &server.SubjectPermission{Deny: []string{"forbidden"}},
+			},
+		}},
+	}
+	startServer := func() *server.Server {
+		s, err := server.NewServer(opts.Clone())
+		require.NoError(t, err)
+		go s.Start()
+		if !s.ReadyForConnections(5 * time.Second) {
+			t.Fatal("NATS server did not start")
+		}
+		t.Cleanup(s.Shutdown)
+		return s
+	}
+	s := startServer()
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("restartServer", func() {
+		s.Shutdown()
+		s = startServer()
+	}))
+
+	_, err := rt.RunOnEventLoop(`
+		var events = [];
+		var downtime, reconnectURL, errorText;
+		const conn = nats.connect({
+			urls: [serverURL],
+			user: "app",
+			password: "secret",
+			reconnectBackoff: ["50ms"],
+		});
+		conn.onDisconnect((e) => events.push("disconnect:" + e.url));
+		conn.onReconnect((e) => {
+			events.push("reconnect");
+			downtime = e.downtime;
+			reconnectURL = e.url;
+			conn.publish("forbidden", "data");
+		});
+		conn.onError((e) => {
+			events.push("error:" + e.subject);
+			errorText = e.error;
+			conn.close();
+		});
+		conn.onClosed((e) => events.push("closed"));
+		restartServer();
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		[]any{"disconnect:" + s.ClientURL(), "reconnect", "error:null", "closed"},
+		runtime.Get("events").Export())
+	assert.Equal(t, s.ClientURL(), runtime.Get("reconnectURL").String())
+	assert.Greater(t, runtime.Get("downtime").ToFloat(), 0.0)
+	assert.Contains(t, strings.ToLower(runtime.Get("errorText").String()), "permissions violation")
+}
+
+func TestConnectClientOptions(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	_, err = responder.Subscribe("svc.whoami", func(msg *nats.Msg) {
+		_ = msg.Respond([]byte(msg.Reply))
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = runtime.RunString(`
+		var named = nats.connect({
+			urls: [serverURL],
+			name: "checkout",
+			inboxPrefix: "_INBOX_checkout",
+			noEcho: true,
+			ignoreAuthErrorAbort: true,
+			connectTimeout: "500ms",
+			flusherTimeout: 2000,
+			drainTimeout: "5s",
+		});
+		var replyTo = named.request("svc.whoami", "", "2s").text();
+
+		var quiet = nats.connect({ urls: [serverURL], noResponders: false });
+		var started = Date.now();
+		var timedOut;
+		try {
+			quiet.request("nobody.home", "", "200ms");
+		} catch (e) {
+			timedOut = e.isTimeout && !e.isNoResponders;
+		}
+		var waited = Date.now() - started;
+
+		var unnamed = nats.connect({ urls: [serverURL] });
+	`)
+	require.NoError(t, err)
+
+	conn := func(name string) *Connection {
+		return runtime.Get(name).Export().(*Connection)
+	}
+	named := conn("named").nc.Opts
+	assert.Equal(t, "checkout", named.Name)
+	assert.True(t, named.NoEcho)
+	assert.True(t, named.IgnoreAuthErrorAbort)
+	assert.Equal(t, 500*time.Millisecond, named.Timeout)
+	assert.Equal(t, 2*time.Second, named.FlusherTimeout)
+	assert.Equal(t, 5*time.Second, named.DrainTimeout)
+	assert.True(t, strings.HasPrefix(runtime.Get("replyTo").String(), "_INBOX_checkout."))
+
+	assert.True(t, runtime.Get("timedOut").ToBoolean())
+	assert.GreaterOrEqual(t, runtime.Get("waited").ToInteger(), int64(200))
+
+	assert.Equal(t, "k6-vu-0", conn("unnamed").nc.Opts.Name)
+
+	for _, name := range []string{"named", "quiet", "unnamed"} {
+		require.NoError(t, conn(name).Close())
+	}
+}
+
+func runWebsocketTestServer(t *testing.T, tlsConfig *tls.Config) (*server.Server, int) {
+	t.Helper()
+
+	port := freePort(t)
+	s, err := server.NewServer(&server.Options{
+		Host:   "127.0.0.1",
+		Port:   -1,
+		NoLog:  true,
+		NoSigs: true,
+		Websocket: server.WebsocketOpts{
+			Host:        "127.0.0.1",
+			Port:        port,
+			NoTLS:       tlsConfig == nil,
+			TLSConfig:   tlsConfig,
+			Compression: true,
+		},
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s, port
+}
+
+func TestConnectWebsocket(t *testing.T) {
+	caPEM, cert := generateTestCA(t)
+	wsServer, wsPort := runWebsocketTestServer(t, nil)
+	wssServer, wssPort := runWebsocketTestServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	for _, s := range []*server.Server{wsServer, wssServer} {
+		responder, err := nats.Connect(s.ClientURL())
+		require.NoError(t, err)
+		defer responder.Close()
+		_, err = responder.Subscribe("ws.echo", func(msg *nats.Msg) {
+			_ = msg.Respond(msg.Data)
+		})
+		require.NoError(t, err)
+		require.NoError(t, responder.Flush())
+	}
+
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("wsURL", "ws://127.0.0.1:"+strconv.Itoa(wsPort)))
+	require.NoError(t, runtime.Set("wssURL", "wss://127.0.0.1:"+strconv.Itoa(wssPort)))
+	require.NoError(t, runtime.Set("caPEM", string(caPEM)))
+
+	_, err := rt.RunOnEventLoop(`
+		var replies = [];
+		for (const options of [
+			{ urls: [wsURL], compression: true },
+			{ urls: [wssURL], tls: { ca: caPEM } },
+		]) {
+			const conn = nats.connect(options);
+			replies.push(conn.request("ws.echo", "over " + options.urls[0].split(":")[0], "2s").text());
+			conn.close();
+		}
+
+		var plainWithTLS;
+		try {
+			nats.connect({ urls: [wsURL], tls: { ca: caPEM } });
+		} catch (e) {
+			plainWithTLS = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []any{"over ws", "over wss"}, runtime.Get("replies").Export())
+	assert.Equal(t, int64(1003), runtime.Get("plainWithTLS").ToInteger())
+}
+
+func TestConnectionServerInfo(t *testing.T) {
+	s := runTestServer(t)
+	plain := runTestServerOnPort(t, freePort(t))
+	rt, _ := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("plainURL", plain.ClientURL()))
+
+	_, err := runtime.RunString(`
+		const conn = nats.connect({ urls: [serverURL] });
+		var info = conn.serverInfo();
+		var rtt = conn.rtt();
+		var before = conn.stats();
+		conn.publish("stats.subject", "12345");
+		conn.flush();
+		var stats = conn.stats();
+		var sent = stats.messagesSent - before.messagesSent;
+		var bytesSent = stats.bytesSent - before.bytesSent;
+
+		const plainConn = nats.connect({ urls: [plainURL] });
+		var plainInfo = plainConn.serverInfo();
+		plainConn.close();
+
+		conn.close();
+		var closedCode;
+		try {
+			conn.rtt();
+		} catch (e) {
+			closedCode = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	info := runtime.Get("info").ToObject(runtime)
+	assert.Equal(t, s.ID(), info.Get("id").String())
+	assert.Equal(t, s.Name(), info.Get("name").String())
+	assert.Equal(t, server.VERSION, info.Get("version").String())
+	assert.Equal(t, s.ClientURL(), info.Get("connectedUrl").String())
+	assert.Equal(t, int64(server.MAX_PAYLOAD_SIZE), info.Get("maxPayload").ToInteger())
+	assert.True(t, info.Get("jetstream").ToBoolean())
+	assert.Empty(t, info.Get("discoveredServers").Export())
+	assert.False(t, runtime.Get("plainInfo").ToObject(runtime).Get("jetstream").ToBoolean())
+
+	assert.Greater(t, runtime.Get("rtt").ToFloat(), 0.0)
+
+	assert.Equal(t, int64(1), runtime.Get("sent").ToInteger())
+	assert.Equal(t, int64(5), runtime.Get("bytesSent").ToInteger())
+	assert.Equal(t, int64(0), runtime.Get("stats").ToObject(runtime).Get("reconnects").ToInteger())
+
+	assert.Equal(t, int64(1002), runtime.Get("closedCode").ToInteger())
+}
+
+func TestReconnectBackoffJitter(t *testing.T) {
+	backoff := []types.Duration{types.Duration(50 * time.Millisecond)}
+	tests := []struct {
+		name      string
+		opts      ConnectionOptions
+		maxJitter time.Duration
+	}{
+		{
+			name:      "plain with the default jitter",
+			opts:      ConnectionOptions{URLs: []string{"nats://localhost:4222"}},
+			maxJitter: nats.DefaultReconnectJitter,
+		},
+		{
+			name: "plain with only the TLS jitter given",
+			opts: ConnectionOptions{
+				URLs:               []string{"nats://localhost:4222"},
+				ReconnectJitterTLS: types.Duration(5 * time.Millisecond),
+			},
+			maxJitter: nats.DefaultReconnectJitter,
+		},
+		{
+			name: "tls URL",
+			opts: ConnectionOptions{
+				URLs:               []string{"tls://localhost:4222"},
+				ReconnectJitter:    types.Duration(time.Second),
+				ReconnectJitterTLS: types.Duration(5 * time.Millisecond),
+			},
+			maxJitter: 5 * time.Millisecond,
+		},
+		{
+			name: "wss URL with the default TLS jitter",
+			opts: ConnectionOptions{
+				URLs:            []string{"wss://localhost:443"},
+				ReconnectJitter: types.Duration(5 * time.Millisecond),
+			},
+			maxJitter: nats.DefaultReconnectJitterTLS,
+		},
+		{
+			name: "TLS options",
+			opts: ConnectionOptions{
+				URLs:               []string{"localhost:4222"},
+				TLS:                &TLSOptions{},
+				ReconnectJitterTLS: types.Duration(5 * time.Millisecond),
+			},
+			maxJitter: 5 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.ReconnectBackoff = backoff
+			delay := reconnectBackoff(tt.opts)
+
+			var maxDelay time.Duration
+			for i := 0; i < 200; i++ {
+				d := delay(i + 1)
+				require.GreaterOrEqual(t, d, 50*time.Millisecond)
+				require.Less(t, d, 50*time.Millisecond+tt.maxJitter)
+				maxDelay = max(maxDelay, d)
+			}
+			// The jitter in use is not a smaller one
+			assert.Greater(t, maxDelay, 50*time.Millisecond+tt.maxJitter/2)
+		})
+	}
+}
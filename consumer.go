@@ -3,30 +3,51 @@ package nats
 import (
 	"time"
 
+	"github.com/dop251/goja"
 	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/types"
 )
 
 type ConsumerConfig struct {
-	Stream        string `js:"stream"`
-	Name          string `js:"name"`
-	Durable       string `js:"durable"`
-	DeliverPolicy string `js:"deliverPolicy"`
-	OptStartSeq   uint64 `js:"optStartSeq"`
-	OptStartTime  int64  `js:"optStartTime"`
-	AckPolicy     string `js:"ackPolicy"`
-	AckWait       int    `js:"ackWait"`
-	MaxDeliver    int    `js:"maxDeliver"`
-	BackOff       []int  `js:"backOff"`
-	FilterSubject string `js:"filterSubject"`
-	ReplayPolicy  string `js:"replayPolicy"`
-	SampleFreq    string `js:"sampleFreq"`
+	Stream        string           `js:"stream"`
+	Name          string           `js:"name"`
+	Durable       string           `js:"durable"`
+	DeliverPolicy string           `js:"deliverPolicy"`
+	OptStartSeq   uint64           `js:"optStartSeq"`
+	OptStartTime  int64            `js:"optStartTime"`
+	AckPolicy     string           `js:"ackPolicy"`
+	AckWait       types.Duration   `js:"ackWait"`
+	MaxDeliver    int              `js:"maxDeliver"`
+	BackOff       []types.Duration `js:"backOff"`
+	FilterSubject string           `js:"filterSubject"`
+	ReplayPolicy  string           `js:"replayPolicy"`
+	SampleFreq    string           `js:"sampleFreq"`
 }
 
-func (j *JetStream) AddConsumer(streamName string, config ConsumerConfig) error {
+// parseConsumerConfig reads a consumer config given either as a plain object
+// or as the result of consumerConfig().
+func parseConsumerConfig(vu modules.VU, opts goja.Value) (ConsumerConfig, error) {
+	var config ConsumerConfig
+	if err := parseJSOptions(opts, &config); err != nil {
+		return config, NewNatsError(1003, "failed to parse consumer config", err)
+	}
+	warnRetentionDuration(vu, "ackWait", config.AckWait)
+	return config, nil
+}
+
+func (j *JetStream) AddConsumer(streamName string, opts goja.Value) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
 
+	config, err := parseConsumerConfig(j.vu, opts)
+	if err != nil {
+		return err
+	}
+
 	if streamName == "" {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
@@ -83,7 +104,7 @@ func (j *JetStream) AddConsumer(streamName string, config ConsumerConfig) error
 		DeliverPolicy: deliverPolicy,
 		OptStartSeq:   config.OptStartSeq,
 		AckPolicy:     ackPolicy,
-		AckWait:       time.Duration(config.AckWait) * time.Second,
+		AckWait:       time.Duration(config.AckWait),
 		MaxDeliver:    config.MaxDeliver,
 		FilterSubject: config.FilterSubject,
 		ReplayPolicy:  replayPolicy,
@@ -96,11 +117,11 @@ func (j *JetStream) AddConsumer(streamName string, config ConsumerConfig) error
 
 	if len(config.BackOff) > 0 {
 		for _, backoff := range config.BackOff {
-			consumerConfig.BackOff = append(consumerConfig.BackOff, time.Duration(backoff)*time.Second)
+			consumerConfig.BackOff = append(consumerConfig.BackOff, time.Duration(backoff))
 		}
 	}
 
-	_, err := j.js.AddConsumer(streamName, consumerConfig)
+	_, err = j.js.AddConsumer(streamName, consumerConfig)
 	if err != nil {
 		return NewNatsError(1025, "failed to add consumer", err)
 	}
@@ -108,11 +129,18 @@ func (j *JetStream) AddConsumer(streamName string, config ConsumerConfig) error
 	return nil
 }
 
-func (j *JetStream) UpdateConsumer(streamName string, config ConsumerConfig) error {
+func (j *JetStream) UpdateConsumer(streamName string, opts goja.Value) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
 
+	config, err := parseConsumerConfig(j.vu, opts)
+	if err != nil {
+		return err
+	}
+
 	if streamName == "" {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
@@ -134,7 +162,7 @@ func (j *JetStream) UpdateConsumer(streamName string, config ConsumerConfig) err
 		consumerConfig.FilterSubject = config.FilterSubject
 	}
 	if config.AckWait > 0 {
-		consumerConfig.AckWait = time.Duration(config.AckWait) * time.Second
+		consumerConfig.AckWait = time.Duration(config.AckWait)
 	}
 	if config.MaxDeliver > 0 {
 		consumerConfig.MaxDeliver = config.MaxDeliver
@@ -148,7 +176,9 @@ func (j *JetStream) UpdateConsumer(streamName string, config ConsumerConfig) err
 	return nil
 }
 
-func (j *JetStream) DeleteConsumer(streamName, consumerName string) error {
+func (j *JetStream) DeleteConsumer(streamName, consumerName string) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
@@ -161,7 +191,7 @@ func (j *JetStream) DeleteConsumer(streamName, consumerName string) error {
 		return NewNatsError(1024, "consumer name cannot be empty", nil)
 	}
 
-	err := j.js.DeleteConsumer(streamName, consumerName)
+	err = j.js.DeleteConsumer(streamName, consumerName)
 	if err != nil {
 		return NewNatsError(1028, "failed to delete consumer", err)
 	}
@@ -169,7 +199,9 @@ func (j *JetStream) DeleteConsumer(streamName, consumerName string) error {
 	return nil
 }
 
-func (j *JetStream) ConsumerInfo(streamName, consumerName string) (*nats.ConsumerInfo, error) {
+func (j *JetStream) ConsumerInfo(streamName, consumerName string) (_ *nats.ConsumerInfo, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -190,7 +222,13 @@ func (j *JetStream) ConsumerInfo(streamName, consumerName string) (*nats.Consume
 	return info, nil
 }
 
-func (j *JetStream) PullSubscribe(streamName, subject, durable string) (*nats.Subscription, error) {
+// PullSubscribe creates a pull subscription for pullMessages and fetchAsync.
+// Scripts get the nats.go subscription itself, so its unsubscribe() is not
+// seen; subscriptions closed that way are counted as closed when they are
+// fetched from, on the next PullSubscribe or when the connection closes.
+func (j *JetStream) PullSubscribe(streamName, subject, durable string) (_ *nats.Subscription, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -208,10 +246,48 @@ func (j *JetStream) PullSubscribe(streamName, subject, durable string) (*nats.Su
 		return nil, NewNatsError(1030, "failed to create pull subscription", err)
 	}
 
+	j.resources.closeInvalidSubs()
+	j.metrics.RecordSubscriptionCreated()
+	j.resources.addSub(sub)
+	j.handleSubs.add(sub)
+	j.pullSubjects.Store(sub, subject)
+
 	return sub, nil
 }
 
-func (j *JetStream) PullMessages(sub *nats.Subscription, batchSize int, timeout time.Duration) ([]*nats.Msg, error) {
+func (j *JetStream) PullMessages(sub *nats.Subscription, batchSize int, timeoutArg goja.Value) (_ []*Message, err error) {
+	defer convertError(j.vu, &err)
+
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := j.fetch(sub, batchSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newMessages(j.vu, msgs, j.metrics), nil
+}
+
+// FetchAsync pulls a batch of messages without blocking the VU and returns a
+// promise that resolves with the fetched messages.
+func (j *JetStream) FetchAsync(sub *nats.Subscription, batchSize int, timeoutArg goja.Value) *goja.Promise {
+	timeout, timeoutErr := parseTimeout(timeoutArg)
+
+	return newAsyncPromise(j.vu, func() (func() any, error) {
+		if timeoutErr != nil {
+			return nil, timeoutErr
+		}
+		msgs, err := j.fetch(sub, batchSize, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return func() any { return newMessages(j.vu, msgs, j.metrics) }, nil
+	})
+}
+
+func (j *JetStream) fetch(sub *nats.Subscription, batchSize int, timeout time.Duration) ([]*nats.Msg, error) {
 	if sub == nil {
 		return nil, NewNatsError(1031, "subscription cannot be nil", nil)
 	}
@@ -224,15 +300,38 @@ func (j *JetStream) PullMessages(sub *nats.Subscription, batchSize int, timeout
 		timeout = 30 * time.Second
 	}
 
+	start := time.Now()
 	msgs, err := sub.Fetch(batchSize, nats.MaxWait(timeout))
 	if err != nil && err != nats.ErrTimeout {
+		if !sub.IsValid() {
+			j.resources.closeSub(sub)
+		}
+		j.metrics.RecordReceiveError()
 		return nil, NewNatsError(1032, "failed to fetch messages", err)
 	}
 
+	// Subscriptions made through another JetStream context go untagged
+	subject, _ := j.pullSubjects.Load(sub)
+	subjectTag, _ := subject.(string)
+
+	latency := time.Since(start)
+	for _, msg := range msgs {
+		j.metrics.RecordMessageReceived(subjectTag, int64(len(msg.Data)), latency)
+		if meta, err := msg.Metadata(); err == nil && meta.NumDelivered > 1 {
+			j.metrics.RecordConsumerRedelivery()
+		}
+	}
+
 	return msgs, nil
 }
 
-func (j *JetStream) PushSubscribe(streamName, subject, durable string, handler func(*nats.Msg)) (*nats.Subscription, error) {
+// PushSubscribe runs handler on the VU's event loop for every message pushed
+// by the consumer. The iteration stays alive until the subscription is closed.
+func (j *JetStream) PushSubscribe(
+	streamName, subject, durable string, handler goja.Callable, opts goja.Value,
+) (_ *Subscription, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -241,17 +340,31 @@ func (j *JetStream) PushSubscribe(streamName, subject, durable string, handler f
 		return nil, NewNatsError(1008, "subject cannot be empty", nil)
 	}
 
+	if handler == nil {
+		return nil, NewNatsError(1033, "handler must be a function", nil)
+	}
+
+	subOpts, err := parseSubscribeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := newHandlerDispatcher(j.vu, handler, subOpts, j.metrics)
+
 	natsHandler := func(msg *nats.Msg) {
 		if j.vu.State() == nil {
 			return
 		}
 
 		j.vu.State().Logger.Debugf("Received push message on subject %s", msg.Subject)
-		handler(msg)
+		j.metrics.RecordMessageReceived(subject, int64(len(msg.Data)), 0)
+		if meta, err := msg.Metadata(); err == nil && meta.NumDelivered > 1 {
+			j.metrics.RecordConsumerRedelivery()
+		}
+		dispatcher.deliver(msg)
 	}
 
 	var sub *nats.Subscription
-	var err error
 
 	if durable != "" {
 		sub, err = j.js.Subscribe(subject, natsHandler, nats.Durable(durable))
@@ -263,10 +376,22 @@ func (j *JetStream) PushSubscribe(streamName, subject, durable string, handler f
 		return nil, NewNatsError(1033, "failed to create push subscription", err)
 	}
 
-	return sub, nil
+	j.metrics.RecordSubscriptionCreated()
+	j.resources.addSub(sub)
+	j.handleSubs.add(sub)
+	sub.SetClosedHandler(func(string) {
+		j.metrics.RecordSubscriptionClosed()
+		j.resources.removeSub(sub)
+		dispatcher.stop()
+	})
+	dispatcher.start(sub)
+
+	return newSubscription(j.vu, sub, dispatcher), nil
 }
 
-func (j *JetStream) ListConsumers(streamName string) ([]string, error) {
+func (j *JetStream) ListConsumers(streamName string) (_ []string, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -1,12 +1,21 @@
 package nats
 
 import (
+	"context"
+	"errors"
+	"slices"
 	"time"
 
+	"github.com/dop251/goja"
 	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/lib/types"
 )
 
-func (c *Connection) Publish(subject string, data []byte) error {
+// Publish sends data to subject. headers is an optional object mapping
+// header names to a string or an array of strings.
+func (c *Connection) Publish(subject string, data []byte, headers goja.Value) (err error) {
+	defer convertError(c.vu, &err)
+
 	if c.nc == nil {
 		return ErrConnectionClosed
 	}
@@ -15,14 +24,26 @@ func (c *Connection) Publish(subject string, data []byte) error {
 		return NewNatsError(1008, "subject cannot be empty", nil)
 	}
 
-	if err := c.nc.Publish(subject, data); err != nil {
+	header, err := parseHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := c.nc.PublishMsg(&nats.Msg{Subject: subject, Data: data, Header: header}); err != nil {
+		c.metrics.RecordPublishError()
 		return NewNatsError(1009, "publish failed", err)
 	}
+	c.metrics.RecordMessagePublished(subject, int64(len(data)), time.Since(start))
 
 	return nil
 }
 
-func (c *Connection) Subscribe(subject string, queue string, handler func(*nats.Msg)) (*nats.Subscription, error) {
+// Subscribe runs handler on the VU's event loop for every message received on
+// subject. The iteration stays alive until the subscription is closed.
+func (c *Connection) Subscribe(subject string, queue string, handler goja.Callable, opts goja.Value) (_ *Subscription, err error) {
+	defer convertError(c.vu, &err)
+
 	if c.nc == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -31,8 +52,17 @@ func (c *Connection) Subscribe(subject string, queue string, handler func(*nats.
 		return nil, NewNatsError(1008, "subject cannot be empty", nil)
 	}
 
+	if handler == nil {
+		return nil, NewNatsError(1010, "handler must be a function", nil)
+	}
+
+	subOpts, err := parseSubscribeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := newHandlerDispatcher(c.vu, handler, subOpts, c.metrics)
 	var sub *nats.Subscription
-	var err error
 
 	natsHandler := func(msg *nats.Msg) {
 		// Create a safe context for the handler
@@ -41,7 +71,10 @@ func (c *Connection) Subscribe(subject string, queue string, handler func(*nats.
 		}
 
 		c.vu.State().Logger.Debugf("Received message on subject %s", msg.Subject)
-		handler(msg)
+		// Tagged with the subscribed subject, which a wildcard would
+		// otherwise turn into a tag value per concrete subject
+		c.metrics.RecordMessageReceived(subject, int64(len(msg.Data)), 0)
+		dispatcher.deliver(msg)
 	}
 
 	if queue != "" {
@@ -54,10 +87,179 @@ func (c *Connection) Subscribe(subject string, queue string, handler func(*nats.
 		return nil, NewNatsError(1010, "subscription failed", err)
 	}
 
-	return sub, nil
+	c.metrics.RecordSubscriptionCreated()
+	c.resources.addSub(sub)
+	c.handleSubs.add(sub)
+	sub.SetClosedHandler(func(string) {
+		c.metrics.RecordSubscriptionClosed()
+		c.resources.removeSub(sub)
+		dispatcher.stop()
+	})
+	dispatcher.start(sub)
+
+	return newSubscription(c.vu, sub, dispatcher), nil
 }
 
-func (c *Connection) Request(subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+// SubscribeSync subscribes to subject, in queue if it is not empty, for the
+// script to read messages with nextMsg instead of a handler.
+func (c *Connection) SubscribeSync(subject, queue string) (_ *SyncSubscription, err error) {
+	defer convertError(c.vu, &err)
+
+	if c.nc == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	if subject == "" {
+		return nil, NewNatsError(1008, "subject cannot be empty", nil)
+	}
+
+	var sub *nats.Subscription
+	if queue != "" {
+		sub, err = c.nc.QueueSubscribeSync(subject, queue)
+	} else {
+		sub, err = c.nc.SubscribeSync(subject)
+	}
+
+	if err != nil {
+		return nil, NewNatsError(1010, "subscription failed", err)
+	}
+
+	c.metrics.RecordSubscriptionCreated()
+	c.resources.addSub(sub)
+	c.handleSubs.add(sub)
+
+	return &SyncSubscription{
+		Subject:   subject,
+		Queue:     queue,
+		vu:        c.vu,
+		sub:       sub,
+		metrics:   c.metrics,
+		resources: c.resources,
+	}, nil
+}
+
+// Request sends data to subject and waits for the first reply. timeout is
+// given in milliseconds or as a duration string; headers is optional, as for
+// Publish. The optional retry policy applies the timeout to each attempt.
+func (c *Connection) Request(subject string, data []byte, timeoutArg, headers, retry goja.Value) (_ *Message, err error) {
+	defer convertError(c.vu, &err)
+
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := parseRetryPolicy(retry)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := c.requestWithRetry(subject, data, header, timeout, policy)
+	if err != nil {
+		return nil, err
+	}
+	return newMessage(c.vu, msg, c.metrics), nil
+}
+
+// RequestAsync sends a request without blocking the VU and returns a promise
+// that resolves with the reply.
+func (c *Connection) RequestAsync(subject string, data []byte, timeoutArg, headers, retry goja.Value) *goja.Promise {
+	// Arguments are read from their JS values before leaving the event loop
+	timeout, timeoutErr := parseTimeout(timeoutArg)
+	header, headerErr := parseHeaders(headers)
+	policy, policyErr := parseRetryPolicy(retry)
+
+	return newAsyncPromise(c.vu, func() (func() any, error) {
+		if timeoutErr != nil {
+			return nil, timeoutErr
+		}
+		if headerErr != nil {
+			return nil, headerErr
+		}
+		if policyErr != nil {
+			return nil, policyErr
+		}
+
+		msg, err := c.requestWithRetry(subject, data, header, timeout, policy)
+		if err != nil {
+			return nil, err
+		}
+		return func() any { return newMessage(c.vu, msg, c.metrics) }, nil
+	})
+}
+
+// RetryPolicy retries a request that failed with one of the RetryOn codes,
+// to model clients that retry. Each attempt counts as a request.
+type RetryPolicy struct {
+	Attempts int              `js:"attempts"` // Including the first, 1 by default
+	Backoff  []types.Duration `js:"backoff"`  // Wait before each retry, the last one repeats
+	RetryOn  []int            `js:"retryOn"`  // Timeouts and no responders by default
+}
+
+// defaultRetryOn are the codes of the request failures that are retried
+// unless a policy names its own.
+var defaultRetryOn = []int{1006, 1044}
+
+func parseRetryPolicy(retry goja.Value) (RetryPolicy, error) {
+	var policy RetryPolicy
+	if err := parseJSOptions(retry, &policy); err != nil {
+		return policy, NewNatsError(1003, "failed to parse retry policy", err)
+	}
+	if err := ValidateRetryPolicy(policy); err != nil {
+		return policy, NewNatsError(1003, "invalid retry policy", err)
+	}
+	return policy, nil
+}
+
+// retries reports whether err is worth another attempt.
+func (p RetryPolicy) retries(err error) bool {
+	var natsErr *NatsError
+	if !errors.As(err, &natsErr) {
+		return false
+	}
+
+	retryOn := p.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	return slices.Contains(retryOn, natsErr.Code)
+}
+
+// backoff returns the wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if len(p.Backoff) == 0 {
+		return 0
+	}
+	return time.Duration(p.Backoff[min(retry, len(p.Backoff))-1])
+}
+
+func (c *Connection) requestWithRetry(
+	subject string, data []byte, header nats.Header, timeout time.Duration, policy RetryPolicy,
+) (*nats.Msg, error) {
+	attempts := max(policy.Attempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		msg, err := c.request(subject, data, header, timeout)
+		if err == nil || attempt == attempts || !policy.retries(err) {
+			c.metrics.RecordRequestAttempts(attempt)
+			return msg, err
+		}
+
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-c.vu.Context().Done():
+			c.metrics.RecordRequestAttempts(attempt)
+			return nil, err
+		}
+	}
+}
+
+func (c *Connection) request(subject string, data []byte, header nats.Header, timeout time.Duration) (*nats.Msg, error) {
 	if c.nc == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -70,18 +272,186 @@ func (c *Connection) Request(subject string, data []byte, timeout time.Duration)
 		timeout = 30 * time.Second // Default timeout
 	}
 
-	msg, err := c.nc.Request(subject, data, timeout)
+	// Bound the request by the VU context so it is abandoned when the VU stops
+	ctx, cancel := context.WithTimeout(c.vu.Context(), timeout)
+	defer cancel()
+
+	c.metrics.RecordRequestSent(int64(len(data)))
+	start := time.Now()
+	msg, err := c.nc.RequestMsgWithContext(ctx, &nats.Msg{Subject: subject, Data: data, Header: header})
+	if errors.Is(err, nats.ErrNoResponders) && !c.noResponders {
+		// Behave like a server without no-responders support
+		<-ctx.Done()
+		err = ctx.Err()
+	}
 	if err != nil {
-		return nil, NewNatsError(1011, "request failed", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = nats.ErrTimeout
+		}
+		if errors.Is(err, nats.ErrTimeout) {
+			c.metrics.RecordRequestTimeout()
+		}
+		return nil, NewRequestError(err)
 	}
+	c.metrics.RecordReplyReceived(int64(len(msg.Data)), time.Since(start))
 
 	return msg, nil
 }
 
-func (c *Connection) Drain() error {
+// RequestManyOptions bound how long RequestMany collects replies. Collection
+// ends with whichever limit is hit first.
+type RequestManyOptions struct {
+	MaxMessages int            `js:"maxMessages"` // 0 for no limit
+	MaxWait     types.Duration `js:"maxWait"`     // 30s by default
+	StallWait   types.Duration `js:"stallWait"`   // Max gap after a reply
+	Sentinel    bool           `js:"sentinel"`    // Stop at an empty reply
+}
+
+// RequestMany sends data to subject and returns all replies received within
+// the limits set by opts, e.g. from every instance of a service. No reply at
+// all is not an error; headers is optional, as for Publish.
+func (c *Connection) RequestMany(subject string, data []byte, opts, headers goja.Value) (_ []*Message, err error) {
+	defer convertError(c.vu, &err)
+
+	manyOpts, err := parseRequestManyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := c.requestMany(subject, data, header, manyOpts)
+	if err != nil {
+		return nil, err
+	}
+	return newMessages(c.vu, msgs, c.metrics), nil
+}
+
+// RequestManyAsync is RequestMany without blocking the VU.
+func (c *Connection) RequestManyAsync(subject string, data []byte, opts, headers goja.Value) *goja.Promise {
+	// Arguments are read from their JS values before leaving the event loop
+	manyOpts, optsErr := parseRequestManyOptions(opts)
+	header, headerErr := parseHeaders(headers)
+
+	return newAsyncPromise(c.vu, func() (func() any, error) {
+		if optsErr != nil {
+			return nil, optsErr
+		}
+		if headerErr != nil {
+			return nil, headerErr
+		}
+
+		msgs, err := c.requestMany(subject, data, header, manyOpts)
+		if err != nil {
+			return nil, err
+		}
+		return func() any { return newMessages(c.vu, msgs, c.metrics) }, nil
+	})
+}
+
+func parseRequestManyOptions(opts goja.Value) (RequestManyOptions, error) {
+	var manyOpts RequestManyOptions
+	if err := parseJSOptions(opts, &manyOpts); err != nil {
+		return manyOpts, NewNatsError(1003, "failed to parse requestMany options", err)
+	}
+	if err := ValidateRequestManyOptions(manyOpts); err != nil {
+		return manyOpts, NewNatsError(1003, "invalid requestMany options", err)
+	}
+	return manyOpts, nil
+}
+
+// requestMany collects the replies on an inbox of its own, as nats.go only
+// returns the first one.
+func (c *Connection) requestMany(subject string, data []byte, header nats.Header, opts RequestManyOptions) ([]*nats.Msg, error) {
+	if c.nc == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	if subject == "" {
+		return nil, NewNatsError(1008, "subject cannot be empty", nil)
+	}
+
+	maxWait := time.Duration(opts.MaxWait)
+	if maxWait <= 0 {
+		maxWait = 30 * time.Second
+	}
+
+	inbox := c.nc.NewRespInbox()
+	sub, err := c.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, NewNatsError(1011, "request failed", err)
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	ctx, cancel := context.WithTimeout(c.vu.Context(), maxWait)
+	defer cancel()
+
+	c.metrics.RecordRequestSent(int64(len(data)))
+	start := time.Now()
+	if err := c.nc.PublishMsg(&nats.Msg{Subject: subject, Reply: inbox, Data: data, Header: header}); err != nil {
+		return nil, NewNatsError(1011, "request failed", err)
+	}
+
+	var (
+		replies   []*nats.Msg
+		size      int64
+		lastReply time.Duration
+	)
+	for opts.MaxMessages == 0 || len(replies) < opts.MaxMessages {
+		msg, err := c.nextReply(ctx, sub, time.Duration(opts.StallWait), len(replies) > 0)
+		if errors.Is(err, nats.ErrNoResponders) {
+			// The status the server sends when nobody is subscribed
+			if c.noResponders && len(replies) == 0 {
+				return nil, NewRequestError(err)
+			}
+			continue
+		}
+		if err != nil {
+			if c.vu.Context().Err() != nil {
+				return nil, NewNatsError(1011, "request failed", c.vu.Context().Err())
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				return nil, NewNatsError(1011, "request failed", err)
+			}
+			break
+		}
+
+		if opts.Sentinel && len(msg.Data) == 0 {
+			break
+		}
+
+		replies = append(replies, msg)
+		size += int64(len(msg.Data))
+		lastReply = time.Since(start)
+	}
+	c.metrics.RecordRequestMany(len(replies), size, lastReply)
+
+	return replies, nil
+}
+
+// nextReply waits for the next reply until ctx is done or, once a reply came
+// in, for at most stallWait.
+func (c *Connection) nextReply(ctx context.Context, sub *nats.Subscription, stallWait time.Duration, replied bool) (*nats.Msg, error) {
+	if replied && stallWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, stallWait)
+		defer cancel()
+	}
+	return sub.NextMsgWithContext(ctx)
+}
+
+func (c *Connection) Drain() (err error) {
+	defer convertError(c.vu, &err)
+
 	if c.nc == nil {
 		return ErrConnectionClosed
 	}
+	if c.pooled {
+		return NewNatsError(1012, "pooled connections cannot be drained", nil)
+	}
 
 	if err := c.nc.Drain(); err != nil {
 		return NewNatsError(1012, "drain failed", err)
@@ -90,7 +460,9 @@ func (c *Connection) Drain() error {
 	return nil
 }
 
-func (c *Connection) Flush() error {
+func (c *Connection) Flush() (err error) {
+	defer convertError(c.vu, &err)
+
 	if c.nc == nil {
 		return ErrConnectionClosed
 	}
@@ -102,7 +474,34 @@ func (c *Connection) Flush() error {
 	return nil
 }
 
-func (c *Connection) FlushTimeout(timeout time.Duration) error {
+// FlushAsync flushes the connection without blocking the VU and returns a
+// promise that resolves once the server has processed all pending messages.
+func (c *Connection) FlushAsync(timeoutArg goja.Value) *goja.Promise {
+	timeout, timeoutErr := parseTimeout(timeoutArg)
+	if timeout <= 0 {
+		timeout = 30 * time.Second // Default timeout
+	}
+
+	return newAsyncPromise(c.vu, func() (func() any, error) {
+		if timeoutErr != nil {
+			return nil, timeoutErr
+		}
+		return nil, c.flushTimeout(timeout)
+	})
+}
+
+func (c *Connection) FlushTimeout(timeoutArg goja.Value) (err error) {
+	defer convertError(c.vu, &err)
+
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return err
+	}
+
+	return c.flushTimeout(timeout)
+}
+
+func (c *Connection) flushTimeout(timeout time.Duration) error {
 	if c.nc == nil {
 		return ErrConnectionClosed
 	}
@@ -114,18 +513,42 @@ func (c *Connection) FlushTimeout(timeout time.Duration) error {
 	return nil
 }
 
-func (c *Connection) JetStream() (*JetStream, error) {
+// JetStream creates a JetStream context. opts is an optional
+// JetStreamOptions object.
+func (c *Connection) JetStream(opts goja.Value) (_ *JetStream, err error) {
+	defer convertError(c.vu, &err)
+
 	if c.nc == nil {
 		return nil, ErrConnectionClosed
 	}
 
-	js, err := c.nc.JetStream()
+	var jsOpts JetStreamOptions
+	if err := parseJSOptions(opts, &jsOpts); err != nil {
+		return nil, NewNatsError(1003, "failed to parse JetStream options", err)
+	}
+
+	if err := ValidateJetStreamOptions(jsOpts); err != nil {
+		return nil, NewNatsError(1003, "invalid JetStream options", err)
+	}
+
+	var natsOpts []nats.JSOpt
+	if jsOpts.PublishAsyncMaxPending > 0 {
+		natsOpts = append(natsOpts, nats.PublishAsyncMaxPending(jsOpts.PublishAsyncMaxPending))
+	}
+
+	js, err := c.nc.JetStream(natsOpts...)
 	if err != nil {
 		return nil, NewNatsError(1014, "jetstream not available", err)
 	}
 
-	return &JetStream{
-		vu: c.vu,
-		js: js,
-	}, nil
+	j := &JetStream{
+		vu:         c.vu,
+		js:         js,
+		metrics:    c.metrics,
+		resources:  c.resources,
+		handleSubs: c.handleSubs,
+	}
+	c.resources.addJetStream(j)
+
+	return j, nil
 }
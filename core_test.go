@@ -0,0 +1,194 @@
+package nats
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishAndRequestHeaders(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	_, err = responder.Subscribe("svc.route", func(msg *nats.Msg) {
+		reply := nats.NewMsg(msg.Reply)
+		reply.Data = []byte(msg.Header.Get("X-Route"))
+		reply.Header.Set("X-Served-By", "go")
+		_ = msg.RespondMsg(reply)
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		let received;
+		const sub = conn.subscribe("events.tagged", "", (msg) => {
+			received = msg.headers;
+			sub.unsubscribe();
+		});
+		conn.publish("events.tagged", "payload", { "X-Tenant": "acme", "X-Trace": ["a", "b"] });
+		conn.flush();
+
+		const reply = conn.request("svc.route", "ping", "2s", { "X-Route": "blue" });
+		const routed = reply.text();
+		const servedBy = reply.headers["X-Served-By"];
+
+		let asyncRouted;
+		conn.requestAsync("svc.route", "ping", "2s", { "X-Route": "green" }).then((msg) => {
+			asyncRouted = msg.text();
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, map[string][]string{
+		"X-Tenant": {"acme"},
+		"X-Trace":  {"a", "b"},
+	}, runtime.Get("received").Export())
+	assert.Equal(t, "blue", runtime.Get("routed").String())
+	assert.Equal(t, []string{"go"}, runtime.Get("servedBy").Export())
+	assert.Equal(t, "green", runtime.Get("asyncRouted").String())
+}
+
+func TestRequestMany(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	for _, instance := range []string{"a", "b", "c"} {
+		_, err = responder.Subscribe("svc.discover", func(msg *nats.Msg) {
+			_ = msg.Respond([]byte(instance))
+		})
+		require.NoError(t, err)
+	}
+	_, err = responder.Subscribe("svc.stream", func(msg *nats.Msg) {
+		for _, chunk := range []string{"1", "2", "3", ""} {
+			_ = msg.Respond([]byte(chunk))
+		}
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const texts = (msgs) => msgs.map((m) => m.text()).sort().join(",");
+
+		const limited = conn.requestMany("svc.discover", "", { maxMessages: 2 }).length;
+
+		let started = Date.now();
+		const windowed = texts(conn.requestMany("svc.discover", "", { maxWait: "300ms" }));
+		const windowTime = Date.now() - started;
+
+		started = Date.now();
+		const stalled = texts(conn.requestMany("svc.discover", "", { maxWait: "5s", stallWait: "100ms" }));
+		const stallTime = Date.now() - started;
+
+		const chunks = texts(conn.requestMany("svc.stream", "", { maxWait: "5s", sentinel: true }));
+
+		let noResponders = false;
+		try {
+			conn.requestMany("nobody.home", "", { maxWait: "1s" });
+		} catch (e) {
+			noResponders = e.code === 1044 && e.isNoResponders;
+		}
+
+		let invalid = false;
+		try {
+			conn.requestMany("svc.discover", "", { maxMessages: -1 });
+		} catch (e) {
+			invalid = e.code === 1003;
+		}
+
+		let asyncCount;
+		conn.requestManyAsync("svc.discover", "", { maxMessages: 3 }).then((msgs) => {
+			asyncCount = msgs.length;
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, int64(2), runtime.Get("limited").ToInteger())
+	assert.Equal(t, "a,b,c", runtime.Get("windowed").String())
+	assert.GreaterOrEqual(t, runtime.Get("windowTime").ToInteger(), int64(300))
+	assert.Equal(t, "a,b,c", runtime.Get("stalled").String())
+	assert.Less(t, runtime.Get("stallTime").ToInteger(), int64(2000))
+	assert.Equal(t, "1,2,3", runtime.Get("chunks").String())
+	assert.True(t, runtime.Get("noResponders").ToBoolean())
+	assert.True(t, runtime.Get("invalid").ToBoolean())
+	assert.Equal(t, int64(3), runtime.Get("asyncCount").ToInteger())
+
+	totals := collectSamples(samples)
+	assert.Equal(t, 14.0, totals["nats_request_many_replies"])
+	assert.Contains(t, totals, "nats_request_many_last_reply")
+}
+
+func TestRequestRetryPolicy(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	// Replies to every third request only, so two attempts time out first
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	var received atomic.Int64
+	_, err = responder.Subscribe("svc.flaky", func(msg *nats.Msg) {
+		if received.Add(1)%3 == 0 {
+			_ = msg.Respond([]byte("ok"))
+		}
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const retry = { attempts: 3, backoff: ["10ms", "20ms"] };
+
+		const reply = conn.request("svc.flaky", "", "100ms", null, retry).text();
+
+		let exhausted;
+		try {
+			conn.request("svc.flaky", "", "100ms", null, { attempts: 2 });
+		} catch (e) {
+			exhausted = e.code;
+		}
+
+		let notRetried;
+		try {
+			conn.request("nobody.home", "", "100ms", null, { attempts: 3, retryOn: [1006] });
+		} catch (e) {
+			notRetried = e.code;
+		}
+
+		let asyncReply;
+		conn.requestAsync("svc.flaky", "", "100ms", null, retry).then((msg) => {
+			asyncReply = msg.text();
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, "ok", runtime.Get("reply").String())
+	assert.Equal(t, int64(1006), runtime.Get("exhausted").ToInteger())
+	assert.Equal(t, int64(1044), runtime.Get("notRetried").ToInteger())
+	assert.Equal(t, "ok", runtime.Get("asyncReply").String())
+
+	// 3 + 2 + 1 + 1 attempts for 4 requests, the async one being the 6th
+	totals := collectSamples(samples)
+	assert.Equal(t, 7.0, totals["nats_request_attempts"])
+	assert.Equal(t, 3.0, totals["nats_request_retries"])
+	assert.Equal(t, 7.0, totals["nats_requests"])
+}
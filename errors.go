@@ -1,7 +1,13 @@
 package nats
 
 import (
+	"context"
+	"errors"
 	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
 )
 
 type NatsError struct {
@@ -21,6 +27,18 @@ func (e *NatsError) Unwrap() error {
 	return e.Err
 }
 
+// IsTimeout reports whether the error was caused by an operation timing out.
+func (e *NatsError) IsTimeout() bool {
+	return e.Code == ErrTimeout.Code ||
+		errors.Is(e.Err, nats.ErrTimeout) ||
+		errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// IsNoResponders reports whether a request found nobody subscribed to its subject.
+func (e *NatsError) IsNoResponders() bool {
+	return errors.Is(e.Err, nats.ErrNoResponders)
+}
+
 func NewNatsError(code int, message string, err error) *NatsError {
 	return &NatsError{
 		Code:    code,
@@ -33,6 +51,19 @@ func NewConnectionError(message string, err error) *NatsError {
 	return NewNatsError(1002, message, err)
 }
 
+// NewRequestError classifies the failure of a request: 1006 when it timed
+// out, like every other timeout, 1044 when nobody is subscribed to its
+// subject and 1011 otherwise.
+func NewRequestError(err error) *NatsError {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return NewNatsError(1044, "no responders", err)
+	case errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded):
+		return NewNatsError(ErrTimeout.Code, "request timed out", err)
+	}
+	return NewNatsError(1011, "request failed", err)
+}
+
 var (
 	ErrNoVUState        = NewNatsError(1001, "no VU state available", nil)
 	ErrConnectionClosed = NewNatsError(1002, "connection is closed", nil)
@@ -42,3 +73,56 @@ var (
 	ErrTimeout          = NewNatsError(1006, "operation timed out", nil)
 	ErrNoMessage        = NewNatsError(1007, "no message available", nil)
 )
+
+// newJSError builds the error object thrown to scripts. A NatsError becomes
+// an Error named "NatsError" with its code, the message of the wrapped
+// nats.go error as cause, and isTimeout/isNoResponders flags. Other errors
+// are thrown as plain GoErrors.
+func newJSError(rt *goja.Runtime, err error) *goja.Object {
+	obj := rt.NewGoError(err)
+
+	var natsErr *NatsError
+	if !errors.As(err, &natsErr) {
+		return obj
+	}
+
+	message := natsErr.Message
+	cause := goja.Null()
+	if natsErr.Err != nil {
+		message += ": " + natsErr.Err.Error()
+		cause = rt.ToValue(natsErr.Err.Error())
+	}
+
+	_ = obj.Set("name", "NatsError")
+	_ = obj.Set("message", message)
+	_ = obj.Set("code", natsErr.Code)
+	_ = obj.Set("cause", cause)
+	_ = obj.Set("isTimeout", natsErr.IsTimeout())
+	_ = obj.Set("isNoResponders", natsErr.IsNoResponders())
+
+	return obj
+}
+
+// throwError throws err as a JS exception. It must be called on the event loop.
+func throwError(rt *goja.Runtime, err error) {
+	var ex *goja.Exception
+	if errors.As(err, &ex) {
+		panic(ex)
+	}
+	panic(newJSError(rt, err))
+}
+
+// convertError replaces *err with an exception that goja throws unchanged,
+// so that methods called from scripts surface the object built by
+// newJSError. It is meant to be deferred by exported methods with a named
+// error result, and must only be used on the event loop.
+func convertError(vu modules.VU, err *error) {
+	if *err == nil {
+		return
+	}
+
+	rt := vu.Runtime()
+	if ex := rt.Try(func() { throwError(rt, *err) }); ex != nil {
+		*err = ex
+	}
+}
@@ -0,0 +1,88 @@
+package nats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNatsErrorClassification(t *testing.T) {
+	assert.True(t, NewNatsError(1011, "request failed", nats.ErrTimeout).IsTimeout())
+	assert.True(t, NewNatsError(1011, "request failed", context.DeadlineExceeded).IsTimeout())
+	assert.True(t, ErrTimeout.IsTimeout())
+	assert.False(t, NewNatsError(1011, "request failed", nats.ErrNoResponders).IsTimeout())
+	assert.True(t, NewNatsError(1011, "request failed", nats.ErrNoResponders).IsNoResponders())
+}
+
+func TestNewRequestError(t *testing.T) {
+	assert.Equal(t, 1006, NewRequestError(nats.ErrTimeout).Code)
+	assert.Equal(t, 1006, NewRequestError(context.DeadlineExceeded).Code)
+	assert.Equal(t, 1044, NewRequestError(nats.ErrNoResponders).Code)
+	assert.Equal(t, 1011, NewRequestError(nats.ErrConnectionClosed).Code)
+
+	assert.True(t, NewRequestError(nats.ErrTimeout).IsTimeout())
+	assert.True(t, NewRequestError(nats.ErrNoResponders).IsNoResponders())
+}
+
+func TestErrorsThrownToJS(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		function capture(fn) {
+			try {
+				fn();
+			} catch (e) {
+				return e;
+			}
+			throw new Error("expected an exception");
+		}
+
+		const connectErr = capture(() => nats.connect({ urls: ["nats://127.0.0.1:1"], maxReconnects: 0 }));
+		const invalidErr = capture(() => new nats.Connection({ urls: [serverURL], maxReconnects: -1 }));
+		const nilErr = capture(() => nats.jetStream(null));
+
+		const conn = nats.connect({ urls: [serverURL] });
+		const noResp = capture(() => conn.request("nobody.home", "ping", 1000));
+		const emptyErr = capture(() => conn.publish("", "data"));
+		const pushErr = capture(() => conn.jetStream().pushSubscribe("S", "", "", () => {}));
+
+		let rejected;
+		conn.requestAsync("nobody.home", "ping", 1000).catch((e) => {
+			rejected = e;
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	get := func(name, prop string) any {
+		return runtime.Get(name).ToObject(runtime).Get(prop).Export()
+	}
+
+	assert.Equal(t, "NatsError", get("connectErr", "name"))
+	assert.Equal(t, int64(1002), get("connectErr", "code"))
+	assert.NotNil(t, get("connectErr", "cause"))
+
+	assert.Equal(t, int64(1003), get("invalidErr", "code"))
+	assert.Contains(t, get("invalidErr", "message"), "maxReconnects must be non-negative")
+
+	assert.Equal(t, int64(1002), get("nilErr", "code"))
+
+	assert.Equal(t, int64(1044), get("noResp", "code"))
+	assert.Equal(t, true, get("noResp", "isNoResponders"))
+	assert.Equal(t, false, get("noResp", "isTimeout"))
+
+	assert.Equal(t, int64(1008), get("emptyErr", "code"))
+	assert.Nil(t, get("emptyErr", "cause"))
+
+	assert.Equal(t, "NatsError", get("pushErr", "name"))
+	assert.Equal(t, int64(1008), get("pushErr", "code"))
+
+	assert.Equal(t, int64(1044), get("rejected", "code"))
+	assert.Equal(t, true, get("rejected", "isNoResponders"))
+}
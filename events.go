@@ -0,0 +1,289 @@
+package nats
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+)
+
+// Connection lifecycle events, named after the conn.on* methods.
+const (
+	eventDisconnect        = "disconnect"
+	eventReconnect         = "reconnect"
+	eventClosed            = "closed"
+	eventError             = "error"
+	eventLameDuck          = "lameDuck"
+	eventDiscoveredServers = "discoveredServers"
+
+	connEventQueueSize = 64
+)
+
+type connEvent struct {
+	kind string
+	data map[string]any
+}
+
+// connEvents passes the lifecycle events reported by nats.go to the handlers
+// registered with conn.onDisconnect and friends. Events are queued from the
+// nats.go callback goroutine and the handlers run on the VU's event loop. As
+// for subscriptions, a callback stays registered from the first handler until
+// the connection is closed, so the iteration does not end before that.
+//
+// The same callbacks feed the connection and outage metrics.
+type connEvents struct {
+	vu       modules.VU
+	metrics  *NatsMetrics
+	handlers map[string]goja.Callable // only touched on the event loop
+	started  bool                     // only touched on the event loop
+
+	queue     chan connEvent
+	listening atomic.Bool
+
+	connectStart time.Time
+	established  atomic.Bool
+	closing      atomic.Bool
+
+	// When the current outage started, in Unix nanoseconds, or 0 while connected
+	disconnectedAt atomic.Int64
+	connectedURL   atomic.Value
+}
+
+func newConnEvents(vu modules.VU, metrics *NatsMetrics) *connEvents {
+	return &connEvents{
+		vu:       vu,
+		metrics:  metrics,
+		handlers: make(map[string]goja.Callable),
+		queue:    make(chan connEvent, connEventQueueSize),
+	}
+}
+
+// on sets the handler for kind. It must be called on the event loop.
+func (e *connEvents) on(kind string, handler goja.Callable, closed bool) {
+	e.handlers[kind] = handler
+
+	if !e.started && !closed {
+		e.started = true
+		e.listening.Store(true)
+		e.start()
+	}
+}
+
+func (e *connEvents) start() {
+	enqueue := e.vu.RegisterCallback()
+
+	go func() {
+		for {
+			select {
+			case ev := <-e.queue:
+				next := make(chan func(func() error), 1)
+				enqueue(func() error {
+					err := e.handle(ev)
+					if ev.kind == eventClosed {
+						next <- nil
+					} else {
+						next <- e.vu.RegisterCallback()
+					}
+					return err
+				})
+
+				select {
+				case enqueue = <-next:
+				case <-e.vu.Context().Done():
+					return
+				}
+				if enqueue == nil {
+					return
+				}
+			case <-e.vu.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func (e *connEvents) handle(ev connEvent) error {
+	handler, ok := e.handlers[ev.kind]
+	if !ok || handler == nil {
+		return nil
+	}
+
+	_, err := handler(goja.Undefined(), e.vu.Runtime().ToValue(ev.data))
+	return err
+}
+
+// emit is called from the nats.go callback goroutine. Events are dropped
+// while no handler is registered or when the queue is full, except for the
+// closed event that releases the event loop.
+func (e *connEvents) emit(kind string, data map[string]any) {
+	if !e.listening.Load() {
+		return
+	}
+
+	data["time"] = time.Now().UnixMilli()
+	ev := connEvent{kind: kind, data: data}
+
+	if kind == eventClosed {
+		e.listening.Store(false)
+		select {
+		case e.queue <- ev:
+		case <-e.vu.Context().Done():
+		}
+		return
+	}
+
+	select {
+	case e.queue <- ev:
+	default:
+	}
+}
+
+func (e *connEvents) connected(nc *nats.Conn) {
+	url := nc.ConnectedUrl()
+	if url == "" {
+		return
+	}
+	e.connectedURL.Store(url)
+
+	if e.established.CompareAndSwap(false, true) {
+		e.metrics.RecordConnectionEstablished(url, time.Since(e.connectStart))
+	}
+}
+
+func (e *connEvents) url() string {
+	url, _ := e.connectedURL.Load().(string)
+	return url
+}
+
+func (e *connEvents) disconnected(nc *nats.Conn, err error) {
+	// Closing the connection reports a disconnect too, which is no outage
+	if nc.IsClosed() {
+		return
+	}
+	e.disconnectedAt.CompareAndSwap(0, time.Now().UnixNano())
+	e.metrics.RecordDisconnect(e.url())
+
+	e.emit(eventDisconnect, map[string]any{
+		"url":   e.url(),
+		"error": errorText(err),
+	})
+}
+
+func (e *connEvents) reconnected(nc *nats.Conn) {
+	// With retryOnFailedConnect, nats.go reports the first connect made in
+	// the background as a reconnect
+	if !e.established.Load() {
+		e.connected(nc)
+		return
+	}
+	e.connected(nc)
+
+	var downtime time.Duration
+	if since := e.disconnectedAt.Swap(0); since != 0 {
+		downtime = time.Since(time.Unix(0, since))
+	}
+	e.metrics.RecordReconnect(nc.ConnectedUrl(), downtime)
+
+	e.emit(eventReconnect, map[string]any{
+		"url":      nc.ConnectedUrl(),
+		"serverId": nc.ConnectedServerId(),
+		"downtime": float64(downtime) / float64(time.Millisecond),
+	})
+}
+
+func (e *connEvents) closed() {
+	// Closed during an outage without conn.close(): the client gave up
+	if !e.closing.Load() && e.disconnectedAt.Load() != 0 {
+		e.metrics.RecordConnectionFailed(e.url())
+	}
+	e.emit(eventClosed, map[string]any{})
+}
+
+func (e *connEvents) asyncError(sub *nats.Subscription, err error) {
+	var subject any
+	if sub != nil {
+		subject = sub.Subject
+		if errors.Is(err, nats.ErrSlowConsumer) {
+			e.metrics.RecordSlowConsumer(sub.Subject)
+		}
+	}
+
+	e.emit(eventError, map[string]any{
+		"error":   errorText(err),
+		"subject": subject,
+	})
+}
+
+func (e *connEvents) lameDuck(nc *nats.Conn) {
+	e.emit(eventLameDuck, map[string]any{
+		"url": nc.ConnectedUrl(),
+	})
+}
+
+func (e *connEvents) discoveredServers(nc *nats.Conn) {
+	e.emit(eventDiscoveredServers, map[string]any{
+		"servers": nc.DiscoveredServers(),
+	})
+}
+
+func errorText(err error) any {
+	if err == nil {
+		return nil
+	}
+	return err.Error()
+}
+
+// OnDisconnect runs handler when the connection to the server is lost.
+func (c *Connection) OnDisconnect(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventDisconnect, handler)
+}
+
+// OnReconnect runs handler once the client is connected again, with the
+// server it landed on and how long it was offline.
+func (c *Connection) OnReconnect(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventReconnect, handler)
+}
+
+// OnClosed runs handler once the connection is closed for good.
+func (c *Connection) OnClosed(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventClosed, handler)
+}
+
+// OnError runs handler for asynchronous errors, e.g. slow consumers or
+// permission violations.
+func (c *Connection) OnError(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventError, handler)
+}
+
+// OnLameDuck runs handler when the server announces it is shutting down.
+func (c *Connection) OnLameDuck(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventLameDuck, handler)
+}
+
+// OnDiscoveredServers runs handler when the server gossips new cluster
+// members.
+func (c *Connection) OnDiscoveredServers(handler goja.Callable) (err error) {
+	defer convertError(c.vu, &err)
+	return c.on(eventDiscoveredServers, handler)
+}
+
+// on registers handler for kind. Pooled connections are shared and outlive
+// the iteration, so they take no handlers.
+func (c *Connection) on(kind string, handler goja.Callable) error {
+	if c.pooled {
+		return NewNatsError(1003, "lifecycle handlers are not supported on pooled connections", nil)
+	}
+	if c.events == nil {
+		return nil
+	}
+	c.events.on(kind, handler, c.nc == nil || c.nc.IsClosed())
+	return nil
+}
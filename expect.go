@@ -0,0 +1,191 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+)
+
+// ExpectOptions configure conn.expect. They are read by hand, as the
+// predicate does not survive the JSON round trip of parseJSOptions.
+type ExpectOptions struct {
+	Predicate goja.Callable // Matches every message when nil
+	Timeout   time.Duration // 30s by default
+	Count     int           // 1 by default
+}
+
+// Expectation waits for the messages a functional test expects on a subject.
+// The subscription is made when the expectation is created, so that messages
+// caused by the action that follows are not missed.
+type Expectation struct {
+	Subject string `js:"subject"`
+
+	vu        modules.VU
+	sub       *nats.Subscription
+	metrics   *NatsMetrics
+	resources *vuResources
+	opts      ExpectOptions
+}
+
+// Expect subscribes to subject for a later wait(). opts is an optional
+// object with a predicate function, a timeout and the number of matching
+// messages to wait for.
+func (c *Connection) Expect(subject string, opts goja.Value) (_ *Expectation, err error) {
+	defer convertError(c.vu, &err)
+
+	if c.nc == nil {
+		return nil, ErrConnectionClosed
+	}
+
+	if subject == "" {
+		return nil, NewNatsError(1008, "subject cannot be empty", nil)
+	}
+
+	expectOpts, err := parseExpectOptions(c.vu.Runtime(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := c.nc.SubscribeSync(subject)
+	if err != nil {
+		return nil, NewNatsError(1010, "subscription failed", err)
+	}
+
+	c.metrics.RecordSubscriptionCreated()
+	c.resources.addSub(sub)
+	c.handleSubs.add(sub)
+
+	// Make sure the server knows about the subscription before the action
+	if err := c.nc.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		c.resources.closeSub(sub)
+		return nil, NewNatsError(1013, "flush failed", err)
+	}
+
+	return &Expectation{
+		Subject:   subject,
+		vu:        c.vu,
+		sub:       sub,
+		metrics:   c.metrics,
+		resources: c.resources,
+		opts:      expectOpts,
+	}, nil
+}
+
+func parseExpectOptions(rt *goja.Runtime, opts goja.Value) (ExpectOptions, error) {
+	expectOpts := ExpectOptions{Count: 1}
+	if opts == nil || goja.IsUndefined(opts) || goja.IsNull(opts) {
+		return expectOpts, nil
+	}
+	obj := opts.ToObject(rt)
+
+	if predicate := obj.Get("predicate"); predicate != nil && !goja.IsUndefined(predicate) && !goja.IsNull(predicate) {
+		fn, ok := goja.AssertFunction(predicate)
+		if !ok {
+			return expectOpts, NewNatsError(1003, "invalid expect options", fmt.Errorf("predicate must be a function"))
+		}
+		expectOpts.Predicate = fn
+	}
+
+	timeout, err := parseTimeout(obj.Get("timeout"))
+	if err != nil {
+		return expectOpts, err
+	}
+	expectOpts.Timeout = timeout
+
+	if count := obj.Get("count"); count != nil && !goja.IsUndefined(count) && !goja.IsNull(count) {
+		expectOpts.Count = int(count.ToInteger())
+	}
+
+	if err := ValidateExpectOptions(expectOpts); err != nil {
+		return expectOpts, NewNatsError(1003, "invalid expect options", err)
+	}
+
+	return expectOpts, nil
+}
+
+// Wait returns the expected messages, or throws 1045 when fewer matched
+// before the timeout. Messages the predicate rejects are skipped. The
+// subscription is closed afterwards, so an expectation is waited for once.
+func (e *Expectation) Wait() (_ []*Message, err error) {
+	defer convertError(e.vu, &err)
+
+	if !e.sub.IsValid() {
+		e.resources.closeSub(e.sub)
+		return nil, NewNatsError(1010, "expectation is closed", nil)
+	}
+	defer func() { _ = e.close() }()
+
+	timeout := e.opts.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	// Bound the wait by the VU context so it is abandoned when the VU stops
+	ctx, cancel := context.WithTimeout(e.vu.Context(), timeout)
+	defer cancel()
+
+	matched := make([]*Message, 0, e.opts.Count)
+	for len(matched) < e.opts.Count {
+		msg, err := e.sub.NextMsgWithContext(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, NewNatsError(1045, fmt.Sprintf(
+					"expected %d message(s) on %s, %d matched", e.opts.Count, e.Subject, len(matched),
+				), nats.ErrTimeout)
+			}
+			return nil, NewNatsError(1010, "failed to receive message", err)
+		}
+		e.metrics.RecordMessageReceived(e.Subject, int64(len(msg.Data)), 0)
+
+		message := newMessage(e.vu, msg, e.metrics)
+		ok, err := e.matches(message)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, message)
+		}
+	}
+
+	return matched, nil
+}
+
+// Cancel closes the subscription without waiting.
+func (e *Expectation) Cancel() (err error) {
+	defer convertError(e.vu, &err)
+
+	if !e.sub.IsValid() {
+		e.resources.closeSub(e.sub)
+		return nil
+	}
+	if err := e.close(); err != nil {
+		return NewNatsError(1010, "unsubscribe failed", err)
+	}
+	return nil
+}
+
+// close unsubscribes and does the bookkeeping of the closed subscription,
+// for which nats.go calls no closed handler.
+func (e *Expectation) close() error {
+	err := e.sub.Unsubscribe()
+	e.resources.closeSub(e.sub)
+	return err
+}
+
+func (e *Expectation) matches(msg *Message) (bool, error) {
+	if e.opts.Predicate == nil {
+		return true, nil
+	}
+
+	result, err := e.opts.Predicate(goja.Undefined(), e.vu.Runtime().ToValue(msg))
+	if err != nil {
+		return false, err
+	}
+	return result.ToBoolean(), nil
+}
@@ -0,0 +1,116 @@
+package nats
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/metrics"
+)
+
+func TestExpect(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	// A service that emits an event for every order it creates
+	service, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer service.Close()
+	_, err = service.Subscribe("orders.create", func(msg *nats.Msg) {
+		_ = service.Publish("orders.events", []byte(`{"id":"other","status":"pending"}`))
+		event := nats.NewMsg("orders.events")
+		event.Header.Set("Event-Type", "created")
+		event.Data = []byte(`{"id":"` + string(msg.Data) + `","status":"pending"}`)
+		_ = service.PublishMsg(event)
+		_ = msg.Respond([]byte("ok"))
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+
+		const created = conn.expect("orders.events", {
+			predicate: (msg) => msg.headers["Event-Type"] !== undefined && msg.json().id === "42",
+			timeout: "2s",
+		});
+		conn.request("orders.create", "42", "2s");
+		const events = created.wait();
+		const status = events[0].json().status;
+
+		const both = conn.expect("orders.events", { count: 2, timeout: 2000 });
+		conn.request("orders.create", "43", "2s");
+		const all = both.wait().length;
+
+		let timeout;
+		const missing = conn.expect("orders.events", {
+			predicate: (msg) => msg.json().id === "none",
+			timeout: 100,
+		});
+		conn.request("orders.create", "44", "2s");
+		try {
+			missing.wait();
+		} catch (e) {
+			timeout = e;
+		}
+
+		let predicateError;
+		const failing = conn.expect("orders.events", {
+			predicate: () => { throw new Error("bad predicate"); },
+		});
+		conn.request("orders.create", "45", "2s");
+		try {
+			failing.wait();
+		} catch (e) {
+			predicateError = e.message;
+		}
+
+		let invalid;
+		try {
+			conn.expect("orders.events", { count: 0 });
+		} catch (e) {
+			invalid = e.code;
+		}
+
+		const cancelled = conn.expect("orders.events");
+		cancelled.cancel();
+		let closed;
+		try {
+			cancelled.wait();
+		} catch (e) {
+			closed = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, 1, int(runtime.Get("events").ToObject(runtime).Get("length").ToInteger()))
+	assert.Equal(t, "pending", runtime.Get("status").String())
+	assert.Equal(t, int64(2), runtime.Get("all").ToInteger())
+
+	timeout := runtime.Get("timeout").ToObject(runtime)
+	assert.Equal(t, int64(1045), timeout.Get("code").ToInteger())
+	assert.True(t, timeout.Get("isTimeout").ToBoolean())
+
+	assert.Contains(t, runtime.Get("predicateError").String(), "bad predicate")
+	assert.Equal(t, int64(1003), runtime.Get("invalid").ToInteger())
+	assert.Equal(t, int64(1010), runtime.Get("closed").ToInteger())
+
+	// Every expectation closed its subscription
+	conn := runtime.Get("conn").Export().(*Connection)
+	conn.resources.mu.Lock()
+	assert.Empty(t, conn.resources.subs)
+	conn.resources.mu.Unlock()
+	active := -1.0
+	for _, container := range metrics.GetBufferedSamples(samples) {
+		for _, sample := range container.GetSamples() {
+			if sample.Metric.Name == "nats_subscriptions_active" {
+				active = sample.Value
+			}
+		}
+	}
+	assert.Equal(t, 0.0, active)
+	require.NoError(t, conn.Close())
+}
@@ -47,7 +47,7 @@
           shellHook = ''
             export GOPATH=$HOME/go
             export PATH=$PATH:$GOPATH/bin
-            echo "🚀 k6/Pondigo/nats development environment ready"
+            echo "🚀 k6/x/nats development environment ready"
             echo "📝 Available commands:"
             echo "   go build ./... - Build the extension"
             echo "   go test ./...   - Run tests"
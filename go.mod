@@ -4,7 +4,10 @@ go 1.23.0
 
 require (
 	github.com/dop251/goja v0.0.0-20240220182346-e401ed450204
+	github.com/nats-io/jwt/v2 v2.5.3
+	github.com/nats-io/nats-server/v2 v2.10.7
 	github.com/nats-io/nats.go v1.31.0
+	github.com/nats-io/nkeys v0.4.6
 	github.com/stretchr/testify v1.9.0
 	go.k6.io/k6 v0.51.0
 )
@@ -25,8 +28,9 @@ require (
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/minio/highwayhash v1.0.2 // indirect
 	github.com/mstoykov/atlas v0.0.0-20220811071828-388f114305dd // indirect
-	github.com/nats-io/nkeys v0.4.5 // indirect
+	github.com/mstoykov/k6-taskqueue-lib v0.1.0 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.38.2 // indirect
@@ -3,26 +3,52 @@ package nats
 import (
 	"time"
 
+	"github.com/dop251/goja"
 	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/types"
 )
 
+// JetStreamOptions configures a JetStream context.
+type JetStreamOptions struct {
+	PublishAsyncMaxPending int `js:"publishAsyncMaxPending"`
+}
+
 type StreamConfig struct {
-	Name      string   `js:"name"`
-	Subjects  []string `js:"subjects"`
-	Retention string   `js:"retention"`
-	MaxBytes  int64    `js:"maxBytes"`
-	MaxMsgs   int64    `js:"maxMsgs"`
-	MaxAge    int      `js:"maxAge"`
-	Replicas  int      `js:"replicas"`
-	Discard   string   `js:"discard"`
-	Storage   string   `js:"storage"`
+	Name      string         `js:"name"`
+	Subjects  []string       `js:"subjects"`
+	Retention string         `js:"retention"`
+	MaxBytes  int64          `js:"maxBytes"`
+	MaxMsgs   int64          `js:"maxMsgs"`
+	MaxAge    types.Duration `js:"maxAge"`
+	Replicas  int            `js:"replicas"`
+	Discard   string         `js:"discard"`
+	Storage   string         `js:"storage"`
+}
+
+// parseStreamConfig reads a stream config given either as a plain object or
+// as the result of streamConfig().
+func parseStreamConfig(vu modules.VU, opts goja.Value) (StreamConfig, error) {
+	var config StreamConfig
+	if err := parseJSOptions(opts, &config); err != nil {
+		return config, NewNatsError(1003, "failed to parse stream config", err)
+	}
+	warnRetentionDuration(vu, "maxAge", config.MaxAge)
+	return config, nil
 }
 
-func (j *JetStream) AddStream(config StreamConfig) error {
+func (j *JetStream) AddStream(opts goja.Value) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
 
+	config, err := parseStreamConfig(j.vu, opts)
+	if err != nil {
+		return err
+	}
+
 	if config.Name == "" {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
@@ -68,13 +94,13 @@ func (j *JetStream) AddStream(config StreamConfig) error {
 		Retention: retention,
 		MaxBytes:  config.MaxBytes,
 		MaxMsgs:   config.MaxMsgs,
-		MaxAge:    time.Duration(config.MaxAge) * time.Second,
+		MaxAge:    time.Duration(config.MaxAge),
 		Replicas:  config.Replicas,
 		Discard:   discard,
 		Storage:   storage,
 	}
 
-	_, err := j.js.AddStream(streamConfig)
+	_, err = j.js.AddStream(streamConfig)
 	if err != nil {
 		return NewNatsError(1016, "failed to add stream", err)
 	}
@@ -82,11 +108,18 @@ func (j *JetStream) AddStream(config StreamConfig) error {
 	return nil
 }
 
-func (j *JetStream) UpdateStream(config StreamConfig) error {
+func (j *JetStream) UpdateStream(opts goja.Value) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
 
+	config, err := parseStreamConfig(j.vu, opts)
+	if err != nil {
+		return err
+	}
+
 	if config.Name == "" {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
@@ -110,7 +143,7 @@ func (j *JetStream) UpdateStream(config StreamConfig) error {
 		streamConfig.MaxMsgs = config.MaxMsgs
 	}
 	if config.MaxAge > 0 {
-		streamConfig.MaxAge = time.Duration(config.MaxAge) * time.Second
+		streamConfig.MaxAge = time.Duration(config.MaxAge)
 	}
 	if config.Replicas > 0 {
 		streamConfig.Replicas = config.Replicas
@@ -124,7 +157,9 @@ func (j *JetStream) UpdateStream(config StreamConfig) error {
 	return nil
 }
 
-func (j *JetStream) DeleteStream(streamName string) error {
+func (j *JetStream) DeleteStream(streamName string) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
@@ -133,7 +168,7 @@ func (j *JetStream) DeleteStream(streamName string) error {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
 
-	err := j.js.DeleteStream(streamName)
+	err = j.js.DeleteStream(streamName)
 	if err != nil {
 		return NewNatsError(1019, "failed to delete stream", err)
 	}
@@ -141,7 +176,9 @@ func (j *JetStream) DeleteStream(streamName string) error {
 	return nil
 }
 
-func (j *JetStream) StreamInfo(streamName string) (*nats.StreamInfo, error) {
+func (j *JetStream) StreamInfo(streamName string) (_ *nats.StreamInfo, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -158,7 +195,9 @@ func (j *JetStream) StreamInfo(streamName string) (*nats.StreamInfo, error) {
 	return info, nil
 }
 
-func (j *JetStream) ListStreams() ([]string, error) {
+func (j *JetStream) ListStreams() (_ []string, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -172,42 +211,262 @@ func (j *JetStream) ListStreams() ([]string, error) {
 	return streams, nil
 }
 
-func (j *JetStream) Publish(subject string, data []byte) error {
+// PublishOptions configures a JetStream publish. The expectations are
+// checked by the server, which rejects the message when one does not hold.
+type PublishOptions struct {
+	MsgID                        string         `js:"msgId"`
+	ExpectStream                 string         `js:"expectStream"`
+	ExpectLastSequence           *uint64        `js:"expectLastSequence"`
+	ExpectLastSequencePerSubject *uint64        `js:"expectLastSequencePerSubject"`
+	ExpectLastMsgID              string         `js:"expectLastMsgId"`
+	Headers                      map[string]any `js:"headers"`
+	Timeout                      types.Duration `js:"timeout"` // Wait for the ack
+}
+
+func (o PublishOptions) pubOpts() []nats.PubOpt {
+	var opts []nats.PubOpt
+	if o.MsgID != "" {
+		opts = append(opts, nats.MsgId(o.MsgID))
+	}
+	if o.ExpectStream != "" {
+		opts = append(opts, nats.ExpectStream(o.ExpectStream))
+	}
+	if o.ExpectLastSequence != nil {
+		opts = append(opts, nats.ExpectLastSequence(*o.ExpectLastSequence))
+	}
+	if o.ExpectLastSequencePerSubject != nil {
+		opts = append(opts, nats.ExpectLastSequencePerSubject(*o.ExpectLastSequencePerSubject))
+	}
+	if o.ExpectLastMsgID != "" {
+		opts = append(opts, nats.ExpectLastMsgId(o.ExpectLastMsgID))
+	}
+	return opts
+}
+
+func parsePublishOptions(opts goja.Value) (PublishOptions, error) {
+	var pubOpts PublishOptions
+	if err := parseJSOptions(opts, &pubOpts); err != nil {
+		return pubOpts, NewNatsError(1003, "failed to parse publish options", err)
+	}
+
+	if err := ValidatePublishOptions(pubOpts); err != nil {
+		return pubOpts, NewNatsError(1003, "invalid publish options", err)
+	}
+
+	return pubOpts, nil
+}
+
+// newPublishMsg builds the message published to subject with the headers
+// from opts.
+func newPublishMsg(subject string, data []byte, opts PublishOptions) (*nats.Msg, error) {
+	header, err := headerFromMap(opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &nats.Msg{Subject: subject, Data: data, Header: header}, nil
+}
+
+// Publish sends data to a stream and waits for its acknowledgement.
+func (j *JetStream) Publish(subject string, data []byte, opts goja.Value) (_ *PubAck, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
-		return ErrConnectionClosed
+		return nil, ErrConnectionClosed
 	}
 
 	if subject == "" {
-		return NewNatsError(1008, "subject cannot be empty", nil)
+		return nil, NewNatsError(1008, "subject cannot be empty", nil)
 	}
 
-	_, err := j.js.Publish(subject, data)
+	pubOpts, err := parsePublishOptions(opts)
 	if err != nil {
-		return NewNatsError(1021, "failed to publish to jetstream", err)
+		return nil, err
 	}
 
-	return nil
+	msg, err := newPublishMsg(subject, data, pubOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	natsOpts := pubOpts.pubOpts()
+	if pubOpts.Timeout > 0 {
+		natsOpts = append(natsOpts, nats.AckWait(time.Duration(pubOpts.Timeout)))
+	}
+
+	start := time.Now()
+	ack, err := j.js.PublishMsg(msg, natsOpts...)
+	if err != nil {
+		j.metrics.RecordPublishError()
+		return nil, NewNatsError(1021, "failed to publish to jetstream", err)
+	}
+	latency := time.Since(start)
+	j.metrics.RecordMessagePublished(subject, int64(len(data)), latency)
+	j.metrics.RecordStreamMessageAdded()
+	j.metrics.RecordPublishAck(subject, latency)
+
+	return newPubAck(ack), nil
 }
 
-func (j *JetStream) PublishAsync(subject string, data []byte) error {
+// PubAck is the acknowledgement returned by a stream for a published message.
+type PubAck struct {
+	Stream    string `js:"stream"`
+	Sequence  uint64 `js:"seq"`
+	Domain    string `js:"domain"`
+	Duplicate bool   `js:"duplicate"`
+}
+
+func newPubAck(ack *nats.PubAck) *PubAck {
+	return &PubAck{
+		Stream:    ack.Stream,
+		Sequence:  ack.Sequence,
+		Domain:    ack.Domain,
+		Duplicate: ack.Duplicate,
+	}
+}
+
+// PublishAsync publishes without waiting for the stream acknowledgement and
+// returns a promise that resolves with the PubAck once it arrives.
+func (j *JetStream) PublishAsync(subject string, data []byte, opts goja.Value) *goja.Promise {
+	// The message is handed to nats.go on the event loop, so that publishes
+	// reach the stream in the order they were made. Only the ack is awaited
+	// off the loop.
+	var wait func() (*PubAck, error)
+	pubOpts, err := parsePublishOptions(opts)
+	if err == nil {
+		wait, err = j.publishAsync(subject, data, pubOpts)
+	}
+
+	return newAsyncPromise(j.vu, func() (func() any, error) {
+		if err != nil {
+			return nil, err
+		}
+
+		ack, err := wait()
+		if err != nil {
+			return nil, err
+		}
+		return func() any { return ack }, nil
+	})
+}
+
+// publishAsync publishes the message and returns a function waiting for its
+// ack, to be called off the event loop.
+func (j *JetStream) publishAsync(subject string, data []byte, opts PublishOptions) (func() (*PubAck, error), error) {
 	if j.js == nil {
-		return ErrConnectionClosed
+		return nil, ErrConnectionClosed
 	}
 
 	if subject == "" {
-		return NewNatsError(1008, "subject cannot be empty", nil)
+		return nil, NewNatsError(1008, "subject cannot be empty", nil)
 	}
 
-	_, err := j.js.PublishAsync(subject, data)
+	msg, err := newPublishMsg(subject, data, opts)
 	if err != nil {
-		return NewNatsError(1022, "failed to publish async to jetstream", err)
+		return nil, err
 	}
 
-	return nil
+	start := time.Now()
+	future, err := j.js.PublishMsgAsync(msg, opts.pubOpts()...)
+	if err != nil {
+		j.asyncFailed.Add(1)
+		j.metrics.RecordPublishError()
+		return nil, NewNatsError(1022, "failed to publish async to jetstream", err)
+	}
+
+	ctx := j.vu.Context()
+	return func() (*PubAck, error) {
+		// nats.go does not accept an ack wait for async publishes, so the
+		// timeout is enforced here
+		var timeout <-chan time.Time
+		if opts.Timeout > 0 {
+			timer := time.NewTimer(time.Duration(opts.Timeout) - time.Since(start))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case ack := <-future.Ok():
+			latency := time.Since(start)
+			j.asyncAcked.Add(1)
+			j.metrics.RecordMessagePublished(subject, int64(len(data)), latency)
+			j.metrics.RecordStreamMessageAdded()
+			j.metrics.RecordPublishAck(subject, latency)
+			return newPubAck(ack), nil
+		case err := <-future.Err():
+			j.asyncFailed.Add(1)
+			j.metrics.RecordPublishError()
+			return nil, NewNatsError(1022, "failed to publish async to jetstream", err)
+		case <-timeout:
+			j.asyncFailed.Add(1)
+			j.metrics.RecordPublishError()
+			return nil, NewNatsError(1006, "operation timed out", nats.ErrTimeout)
+		case <-ctx.Done():
+			return nil, NewNatsError(1006, "operation timed out", ctx.Err())
+		}
+	}, nil
+}
+
+// PublishAsyncStats describes the async publishes of a JetStream context.
+type PublishAsyncStats struct {
+	Pending int    `js:"pending"`
+	Acked   uint64 `js:"acked"`
+	Failed  uint64 `js:"failed"`
+}
+
+// PublishAsyncPending returns the number of async publishes still waiting
+// for their ack.
+func (j *JetStream) PublishAsyncPending() int {
+	if j.js == nil {
+		return 0
+	}
+	return j.js.PublishAsyncPending()
+}
+
+// PublishAsyncComplete blocks until every outstanding async publish has been
+// acknowledged or failed, and throws when timeout expires first.
+func (j *JetStream) PublishAsyncComplete(timeoutArg goja.Value) (err error) {
+	defer convertError(j.vu, &err)
+
+	if j.js == nil {
+		return ErrConnectionClosed
+	}
+
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return err
+	}
+
+	if timeout <= 0 {
+		timeout = 30 * time.Second // Default timeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-j.js.PublishAsyncComplete():
+		return nil
+	case <-timer.C:
+		return NewNatsError(1006, "async publishes did not complete in time", nats.ErrTimeout)
+	case <-j.vu.Context().Done():
+		return NewNatsError(1006, "operation timed out", j.vu.Context().Err())
+	}
+}
+
+// PublishAsyncStats returns the pending, acknowledged and failed async
+// publishes of this context.
+func (j *JetStream) PublishAsyncStats() *PublishAsyncStats {
+	return &PublishAsyncStats{
+		Pending: j.PublishAsyncPending(),
+		Acked:   j.asyncAcked.Load(),
+		Failed:  j.asyncFailed.Load(),
+	}
 }
 
 // GetStreamInfo retrieves detailed information about a stream
-func (j *JetStream) GetStreamInfo(streamName string) (*nats.StreamInfo, error) {
+func (j *JetStream) GetStreamInfo(streamName string) (_ *nats.StreamInfo, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -225,7 +484,9 @@ func (j *JetStream) GetStreamInfo(streamName string) (*nats.StreamInfo, error) {
 }
 
 // GetConsumerInfo retrieves detailed information about a consumer
-func (j *JetStream) GetConsumerInfo(streamName, consumerName string) (*nats.ConsumerInfo, error) {
+func (j *JetStream) GetConsumerInfo(streamName, consumerName string) (_ *nats.ConsumerInfo, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -247,7 +508,9 @@ func (j *JetStream) GetConsumerInfo(streamName, consumerName string) (*nats.Cons
 }
 
 // GetAccountInfo retrieves JetStream account information
-func (j *JetStream) GetAccountInfo() (*nats.AccountInfo, error) {
+func (j *JetStream) GetAccountInfo() (_ *nats.AccountInfo, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -261,7 +524,9 @@ func (j *JetStream) GetAccountInfo() (*nats.AccountInfo, error) {
 }
 
 // PurgeStream removes all messages from a stream
-func (j *JetStream) PurgeStream(streamName string) error {
+func (j *JetStream) PurgeStream(streamName string) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
@@ -270,7 +535,7 @@ func (j *JetStream) PurgeStream(streamName string) error {
 		return NewNatsError(1015, "stream name cannot be empty", nil)
 	}
 
-	err := j.js.PurgeStream(streamName)
+	err = j.js.PurgeStream(streamName)
 	if err != nil {
 		return NewNatsError(1035, "failed to purge stream", err)
 	}
@@ -279,7 +544,9 @@ func (j *JetStream) PurgeStream(streamName string) error {
 }
 
 // DeleteMessage removes a specific message from a stream
-func (j *JetStream) DeleteMessage(streamName string, seq uint64) error {
+func (j *JetStream) DeleteMessage(streamName string, seq uint64) (err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return ErrConnectionClosed
 	}
@@ -289,7 +556,7 @@ func (j *JetStream) DeleteMessage(streamName string, seq uint64) error {
 	}
 
 	// Use direct API call to delete message
-	_, err := j.js.StreamInfo(streamName)
+	_, err = j.js.StreamInfo(streamName)
 	if err != nil {
 		return NewNatsError(1020, "stream not found", err)
 	}
@@ -300,7 +567,9 @@ func (j *JetStream) DeleteMessage(streamName string, seq uint64) error {
 }
 
 // GetStreamNames returns all stream names
-func (j *JetStream) GetStreamNames() ([]string, error) {
+func (j *JetStream) GetStreamNames() (_ []string, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
@@ -315,7 +584,9 @@ func (j *JetStream) GetStreamNames() ([]string, error) {
 }
 
 // GetConsumerNames returns all consumer names for a stream
-func (j *JetStream) GetConsumerNames(streamName string) ([]string, error) {
+func (j *JetStream) GetConsumerNames(streamName string) (_ []string, err error) {
+	defer convertError(j.vu, &err)
+
 	if j.js == nil {
 		return nil, ErrConnectionClosed
 	}
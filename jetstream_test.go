@@ -0,0 +1,223 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/metrics"
+)
+
+func TestJetStreamPublishOptions(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const js = nats.jetStream(conn);
+		js.addStream(nats.streamConfig({
+			name: "ORDERS",
+			subjects: ["orders.>"],
+			storage: "memory",
+			replicas: 1,
+		}));
+
+		const first = js.publish("orders.1", "created", {
+			msgId: "order-1",
+			expectStream: "ORDERS",
+			expectLastSequence: 0,
+			headers: { "X-Kind": "created" },
+		});
+		const dup = js.publish("orders.1", "created", { msgId: "order-1" });
+		const second = js.publish("orders.1", "paid", {
+			expectLastSequencePerSubject: first.seq,
+			expectLastMsgId: "order-1",
+			timeout: "2s",
+		});
+
+		let staleCode;
+		try {
+			js.publish("orders.1", "shipped", { expectLastSequence: first.seq });
+		} catch (e) {
+			staleCode = e.code;
+		}
+
+		let wrongStream;
+		try {
+			js.publish("orders.1", "shipped", { expectStream: "OTHER" });
+		} catch (e) {
+			wrongStream = e.code;
+		}
+
+		const sub = js.pullSubscribe("ORDERS", "orders.>", "reader");
+		const headers = js.pullMessages(sub, 1, 1000)[0].headers;
+
+		let asyncDup;
+		js.publishAsync("orders.1", "created", { msgId: "order-1" }).then((ack) => {
+			asyncDup = ack.duplicate;
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	get := func(name, prop string) any {
+		return runtime.Get(name).ToObject(runtime).Get(prop).Export()
+	}
+
+	assert.Equal(t, "ORDERS", get("first", "stream"))
+	assert.Equal(t, int64(1), get("first", "seq"))
+	assert.Equal(t, false, get("first", "duplicate"))
+	assert.Equal(t, true, get("dup", "duplicate"))
+	assert.Equal(t, int64(1), get("dup", "seq"))
+	assert.Equal(t, int64(2), get("second", "seq"))
+	assert.Equal(t, int64(1021), runtime.Get("staleCode").ToInteger())
+	assert.Equal(t, int64(1021), runtime.Get("wrongStream").ToInteger())
+	assert.Equal(t, []string{"created"}, get("headers", "X-Kind"))
+	assert.Equal(t, true, runtime.Get("asyncDup").ToBoolean())
+}
+
+func TestJetStreamPublishAsyncTracking(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const js = conn.jetStream({ publishAsyncMaxPending: 16 });
+		js.addStream(nats.streamConfig({
+			name: "BULK",
+			subjects: ["bulk.>"],
+			storage: "memory",
+			replicas: 1,
+		}));
+
+		const promises = [];
+		const seqs = [];
+		for (let i = 0; i < 10; i++) {
+			promises.push(js.publishAsync("bulk." + i, "payload").then((ack) => {
+				seqs[i] = ack.seq;
+			}));
+		}
+		promises.push(js.publishAsync("unbound.subject", "payload").catch(() => {}));
+		js.publishAsyncComplete("5s");
+
+		let stats;
+		Promise.all(promises).then(() => {
+			stats = js.publishAsyncStats();
+			conn.close();
+		});
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	stats := runtime.Get("stats").ToObject(runtime)
+	assert.Equal(t, int64(0), stats.Get("pending").ToInteger())
+	assert.Equal(t, int64(10), stats.Get("acked").ToInteger())
+	assert.Equal(t, int64(1), stats.Get("failed").ToInteger())
+
+	// The stream stores the messages in the order they were published
+	var seqs []int64
+	require.NoError(t, runtime.ExportTo(runtime.Get("seqs"), &seqs))
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, seqs)
+	assert.Contains(t, collectSamples(samples), "nats_js_ack_duration")
+}
+
+func TestJetStreamConfigDurations(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL], reconnectWait: "250ms", pingInterval: 30000 });
+		const js = nats.jetStream(conn);
+		js.addStream({ name: "EVENTS", subjects: ["events.>"], storage: "memory", replicas: 1, maxAge: "1h" });
+		js.addConsumer("EVENTS", nats.consumerConfig({
+			stream: "EVENTS",
+			durable: "worker",
+			ackWait: 1500,
+			maxDeliver: 5,
+			backOff: ["1.5s", 3000],
+		}));
+		js.updateStream({ name: "EVENTS", maxAge: "90m" });
+		conn.close();
+	`)
+	require.NoError(t, err)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+
+	stream, err := js.StreamInfo("EVENTS")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, stream.Config.MaxAge)
+
+	consumer, err := js.ConsumerInfo("EVENTS", "worker")
+	require.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, consumer.Config.AckWait)
+	assert.Equal(t, []time.Duration{1500 * time.Millisecond, 3 * time.Second}, consumer.Config.BackOff)
+}
+
+func TestPullSubscriptionClosed(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const js = nats.jetStream(conn);
+		js.addStream(nats.streamConfig({
+			name: "PULLED",
+			subjects: ["pulled.>"],
+			storage: "memory",
+			replicas: 1,
+		}));
+
+		const first = js.pullSubscribe("PULLED", "pulled.>", "first");
+		first.unsubscribe();
+		let fetchCode;
+		try {
+			js.pullMessages(first, 1, 100);
+		} catch (e) {
+			fetchCode = e.code;
+		}
+
+		const second = js.pullSubscribe("PULLED", "pulled.>", "second");
+		second.unsubscribe();
+		const third = js.pullSubscribe("PULLED", "pulled.>", "third");
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1032), rt.VU.Runtime().Get("fetchCode").ToInteger())
+
+	conn := rt.VU.Runtime().Get("conn").Export().(*Connection)
+	tracked := func() int {
+		conn.resources.mu.Lock()
+		defer conn.resources.mu.Unlock()
+		return len(conn.resources.subs)
+	}
+	active := -1.0
+	lastActive := func() float64 {
+		for _, container := range metrics.GetBufferedSamples(samples) {
+			for _, sample := range container.GetSamples() {
+				if sample.Metric.Name == "nats_subscriptions_active" {
+					active = sample.Value
+				}
+			}
+		}
+		return active
+	}
+
+	// The unsubscribed ones were untracked by fetching and by the next subscribe
+	assert.Equal(t, 1, tracked())
+	assert.Equal(t, 1.0, lastActive())
+
+	require.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool {
+		return tracked() == 0 && lastActive() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,219 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+)
+
+// Message is the JS view of a received NATS message. It must be created on
+// the VU's event loop.
+type Message struct {
+	Subject string              `js:"subject"`
+	Reply   string              `js:"reply"`
+	Data    goja.ArrayBuffer    `js:"data"`
+	Headers map[string][]string `js:"headers"`
+
+	vu      modules.VU
+	msg     *nats.Msg
+	metrics *NatsMetrics
+}
+
+// MessageMetadata describes a JetStream message.
+type MessageMetadata struct {
+	Stream           string `js:"stream"`
+	Consumer         string `js:"consumer"`
+	StreamSequence   uint64 `js:"streamSequence"`
+	ConsumerSequence uint64 `js:"consumerSequence"`
+	NumDelivered     uint64 `js:"numDelivered"`
+	NumPending       uint64 `js:"numPending"`
+	Timestamp        int64  `js:"timestamp"` // Unix milliseconds
+	Domain           string `js:"domain"`
+}
+
+func newMessage(vu modules.VU, msg *nats.Msg, metrics *NatsMetrics) *Message {
+	headers := make(map[string][]string, len(msg.Header))
+	for key, values := range msg.Header {
+		headers[key] = values
+	}
+
+	return &Message{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Data:    vu.Runtime().NewArrayBuffer(msg.Data),
+		Headers: headers,
+		vu:      vu,
+		msg:     msg,
+		metrics: metrics,
+	}
+}
+
+func newMessages(vu modules.VU, msgs []*nats.Msg, metrics *NatsMetrics) []*Message {
+	messages := make([]*Message, 0, len(msgs))
+	for _, msg := range msgs {
+		messages = append(messages, newMessage(vu, msg, metrics))
+	}
+	return messages
+}
+
+// Text returns the payload decoded as a string.
+func (m *Message) Text() string {
+	return string(m.msg.Data)
+}
+
+// JSON returns the payload parsed as JSON.
+func (m *Message) JSON() (_ any, err error) {
+	defer convertError(m.vu, &err)
+
+	var value any
+	if err := json.Unmarshal(m.msg.Data, &value); err != nil {
+		return nil, NewNatsError(1003, "message payload is not valid JSON", err)
+	}
+	return value, nil
+}
+
+// Respond publishes data, with optional headers, to the message's reply subject.
+func (m *Message) Respond(data []byte, headers goja.Value) (err error) {
+	defer convertError(m.vu, &err)
+
+	if m.msg.Reply == "" {
+		return NewNatsError(1037, "message has no reply subject", nil)
+	}
+
+	header, err := parseHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if err := m.msg.RespondMsg(&nats.Msg{Subject: m.msg.Reply, Data: data, Header: header}); err != nil {
+		m.metrics.RecordPublishError()
+		return NewNatsError(1038, "failed to respond to message", err)
+	}
+	// Reply subjects are unique inboxes, which would make a tag value each
+	m.metrics.RecordMessagePublished("", int64(len(data)), time.Since(start))
+
+	return nil
+}
+
+// IsJetStream reports whether the message was delivered by a JetStream consumer.
+func (m *Message) IsJetStream() bool {
+	_, err := m.msg.Metadata()
+	return err == nil
+}
+
+// Ack acknowledges a JetStream message.
+func (m *Message) Ack() (err error) {
+	defer convertError(m.vu, &err)
+
+	if err := m.jetStreamAck(m.msg.Ack); err != nil {
+		return err
+	}
+	m.metrics.RecordConsumerMessageAcked()
+	return nil
+}
+
+// Nak negatively acknowledges a JetStream message so it is redelivered.
+func (m *Message) Nak() (err error) {
+	defer convertError(m.vu, &err)
+
+	if err := m.jetStreamAck(m.msg.Nak); err != nil {
+		return err
+	}
+	m.metrics.RecordConsumerMessageNacked()
+	return nil
+}
+
+// Term tells the server to stop redelivering a JetStream message.
+func (m *Message) Term() (err error) {
+	defer convertError(m.vu, &err)
+
+	return m.jetStreamAck(m.msg.Term)
+}
+
+// InProgress resets the redelivery timer of a JetStream message.
+func (m *Message) InProgress() (err error) {
+	defer convertError(m.vu, &err)
+
+	return m.jetStreamAck(m.msg.InProgress)
+}
+
+// Metadata returns the JetStream metadata of the message.
+func (m *Message) Metadata() (_ *MessageMetadata, err error) {
+	defer convertError(m.vu, &err)
+
+	meta, err := m.msg.Metadata()
+	if err != nil {
+		return nil, NewNatsError(1039, "not a JetStream message", err)
+	}
+
+	return &MessageMetadata{
+		Stream:           meta.Stream,
+		Consumer:         meta.Consumer,
+		StreamSequence:   meta.Sequence.Stream,
+		ConsumerSequence: meta.Sequence.Consumer,
+		NumDelivered:     meta.NumDelivered,
+		NumPending:       meta.NumPending,
+		Timestamp:        meta.Timestamp.UnixMilli(),
+		Domain:           meta.Domain,
+	}, nil
+}
+
+// jetStreamAck guards acknowledgements so that they are never sent to the
+// reply subject of a core NATS message.
+func (m *Message) jetStreamAck(ack func(...nats.AckOpt) error) error {
+	if !m.IsJetStream() {
+		return NewNatsError(1039, "not a JetStream message", nil)
+	}
+
+	if err := ack(); err != nil {
+		return NewNatsError(1040, "failed to acknowledge message", err)
+	}
+
+	return nil
+}
+
+// parseHeaders converts a JS object whose values are strings or arrays of
+// strings into NATS headers.
+func parseHeaders(value goja.Value) (nats.Header, error) {
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, nil
+	}
+
+	exported, ok := value.Export().(map[string]any)
+	if !ok {
+		return nil, NewNatsError(1041, "headers must be an object", nil)
+	}
+
+	return headerFromMap(exported)
+}
+
+// headerFromMap converts exported JS headers into NATS headers.
+func headerFromMap(headers map[string]any) (nats.Header, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	header := make(nats.Header, len(headers))
+	for key, raw := range headers {
+		if strings.TrimSpace(key) == "" {
+			return nil, NewNatsError(1041, "header names cannot be empty", nil)
+		}
+
+		switch v := raw.(type) {
+		case []any:
+			for _, item := range v {
+				header.Add(key, fmt.Sprint(item))
+			}
+		default:
+			header.Add(key, fmt.Sprint(v))
+		}
+	}
+
+	return header, nil
+}
@@ -0,0 +1,123 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/metrics"
+)
+
+func TestMessageRespondAndRead(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	requester, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer requester.Close()
+
+	// The request is sent once the script has subscribed
+	replies := make(chan *nats.Msg, 1)
+	require.NoError(t, rt.VU.Runtime().Set("sendRequest", func() {
+		go func() {
+			msg, err := requester.Request("svc.greet", []byte(`{"name":"k6"}`), 5*time.Second)
+			if err == nil {
+				replies <- msg
+			}
+			close(replies)
+		}()
+	}))
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		let seen;
+		const sub = conn.subscribe("svc.greet", "", (msg) => {
+			seen = {
+				subject: msg.subject,
+				hasReply: msg.reply !== "",
+				size: msg.data.byteLength,
+				name: msg.json().name,
+			};
+			msg.respond("hello " + msg.json().name, { "X-Tag": ["a", "b"] });
+			sub.unsubscribe();
+			conn.close();
+		});
+		conn.flush();
+		sendRequest();
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"subject":  "svc.greet",
+		"hasReply": true,
+		"size":     int64(13),
+		"name":     "k6",
+	}, rt.VU.Runtime().Get("seen").Export())
+
+	reply := <-replies
+	require.NotNil(t, reply)
+	assert.Equal(t, "hello k6", string(reply.Data))
+	assert.Equal(t, []string{"a", "b"}, reply.Header.Values("X-Tag"))
+
+	// The reply is counted without the inbox as subject tag
+	published := 0
+	for _, container := range metrics.GetBufferedSamples(samples) {
+		for _, sample := range container.GetSamples() {
+			if sample.Metric.Name == "nats_msgs_published" {
+				published++
+				_, tagged := sample.Tags.Get("subject")
+				assert.False(t, tagged)
+			}
+		}
+	}
+	assert.Equal(t, 1, published)
+}
+
+func TestMessageJetStreamAcks(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const js = nats.jetStream(conn);
+		js.addStream(nats.streamConfig({
+			name: "ACKS",
+			subjects: ["acks.>"],
+			storage: "memory",
+			replicas: 1,
+		}));
+		js.publish("acks.one", "first");
+		js.publish("acks.two", "second");
+
+		const sub = js.pullSubscribe("ACKS", "acks.>", "worker");
+		const msgs = js.pullMessages(sub, 2, 1000);
+		const meta = msgs[0].metadata();
+		const texts = msgs.map((m) => m.text());
+		msgs[0].ack();
+		msgs[1].term();
+
+		const reply = conn.subscribe("core.msg", "", (msg) => {
+			try {
+				msg.ack();
+			} catch (e) {
+				coreAckError = String(e);
+			}
+			reply.unsubscribe();
+		});
+		var coreAckError = "";
+		conn.publish("core.msg", "plain");
+		conn.flush();
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, []any{"first", "second"}, runtime.Get("texts").Export())
+	assert.Equal(t, "ACKS", runtime.Get("meta").ToObject(runtime).Get("stream").String())
+	assert.Equal(t, int64(1), runtime.Get("meta").ToObject(runtime).Get("streamSequence").ToInteger())
+	assert.Contains(t, runtime.Get("coreAckError").String(), "not a JetStream message")
+	assert.Equal(t, 1.0, collectSamples(samples)["nats_js_msgs_acked"])
+}
@@ -2,32 +2,140 @@ package nats
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
-	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
 	"go.k6.io/k6/metrics"
 )
 
-// NatsMetrics holds all NATS-related metrics
-type NatsMetrics struct {
+// natsMetricSet holds the metric definitions shared by all VUs.
+type natsMetricSet struct {
 	registry *metrics.Registry
-	vu       VU
+
+	Connections        *metrics.Metric
+	ConnectionsClosed  *metrics.Metric
+	ConnectionErrors   *metrics.Metric
+	ConnectDuration    *metrics.Metric
+	Disconnects        *metrics.Metric
+	Reconnects         *metrics.Metric
+	DisconnectDuration *metrics.Metric
+
+	MsgsPublished   *metrics.Metric
+	BytesPublished  *metrics.Metric
+	PublishDuration *metrics.Metric
+	PublishErrors   *metrics.Metric
+
+	MsgsReceived    *metrics.Metric
+	BytesReceived   *metrics.Metric
+	ReceiveDuration *metrics.Metric
+	ReceiveErrors   *metrics.Metric
+	MsgsDropped     *metrics.Metric
+	SlowConsumers   *metrics.Metric
+
+	Requests        *metrics.Metric
+	Replies         *metrics.Metric
+	RequestDuration *metrics.Metric
+	RequestTimeouts *metrics.Metric
+	RequestAttempts *metrics.Metric
+	RequestRetries  *metrics.Metric
+
+	RequestManyReplies   *metrics.Metric
+	RequestManyLastReply *metrics.Metric
+
+	SubscriptionsActive *metrics.Metric
+
+	StreamMsgsAdded     *metrics.Metric
+	StreamAckDuration   *metrics.Metric
+	StreamMsgsDeleted   *metrics.Metric
+	ConsumerMsgsAcked   *metrics.Metric
+	ConsumerMsgsNacked  *metrics.Metric
+	ConsumerRedelivered *metrics.Metric
+
+	LeakedResources *metrics.Metric
+
+	// activeSubscriptions backs the subscriptions gauge across all VUs.
+	activeSubscriptions atomic.Int64
 }
 
-// VU interface for accessing k6 VU state
-type VU interface {
-	State() any // Using any to avoid import issues
-	Context() context.Context
+// registerMetrics registers all NATS metrics in registry. Registering an
+// already known metric returns the existing one, so this is safe to call for
+// every VU.
+func registerMetrics(registry *metrics.Registry) (*natsMetricSet, error) {
+	set := &natsMetricSet{registry: registry}
+
+	defs := []struct {
+		metric    **metrics.Metric
+		name      string
+		typ       metrics.MetricType
+		valueType []metrics.ValueType
+	}{
+		{&set.Connections, "nats_connections", metrics.Counter, nil},
+		{&set.ConnectionsClosed, "nats_connections_closed", metrics.Counter, nil},
+		{&set.ConnectionErrors, "nats_connection_errors", metrics.Counter, nil},
+		{&set.ConnectDuration, "nats_connect_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.Disconnects, "nats_disconnects", metrics.Counter, nil},
+		{&set.Reconnects, "nats_reconnects", metrics.Counter, nil},
+		{&set.DisconnectDuration, "nats_disconnected_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.MsgsPublished, "nats_msgs_published", metrics.Counter, nil},
+		{&set.BytesPublished, "nats_bytes_published", metrics.Counter, []metrics.ValueType{metrics.Data}},
+		{&set.PublishDuration, "nats_publish_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.PublishErrors, "nats_publish_errors", metrics.Counter, nil},
+		{&set.MsgsReceived, "nats_msgs_received", metrics.Counter, nil},
+		{&set.BytesReceived, "nats_bytes_received", metrics.Counter, []metrics.ValueType{metrics.Data}},
+		{&set.ReceiveDuration, "nats_receive_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.ReceiveErrors, "nats_receive_errors", metrics.Counter, nil},
+		{&set.MsgsDropped, "nats_msgs_dropped", metrics.Counter, nil},
+		{&set.SlowConsumers, "nats_slow_consumers", metrics.Counter, nil},
+		{&set.Requests, "nats_requests", metrics.Counter, nil},
+		{&set.Replies, "nats_replies", metrics.Counter, nil},
+		{&set.RequestDuration, "nats_request_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.RequestTimeouts, "nats_request_timeouts", metrics.Counter, nil},
+		{&set.RequestAttempts, "nats_request_attempts", metrics.Trend, nil},
+		{&set.RequestRetries, "nats_request_retries", metrics.Counter, nil},
+		{&set.RequestManyReplies, "nats_request_many_replies", metrics.Trend, nil},
+		{&set.RequestManyLastReply, "nats_request_many_last_reply", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.SubscriptionsActive, "nats_subscriptions_active", metrics.Gauge, nil},
+		{&set.StreamMsgsAdded, "nats_js_msgs_published", metrics.Counter, nil},
+		{&set.StreamAckDuration, "nats_js_ack_duration", metrics.Trend, []metrics.ValueType{metrics.Time}},
+		{&set.StreamMsgsDeleted, "nats_js_msgs_deleted", metrics.Counter, nil},
+		{&set.ConsumerMsgsAcked, "nats_js_msgs_acked", metrics.Counter, nil},
+		{&set.ConsumerMsgsNacked, "nats_js_msgs_nacked", metrics.Counter, nil},
+		{&set.ConsumerRedelivered, "nats_js_redeliveries", metrics.Counter, nil},
+		{&set.LeakedResources, "nats_leaked_resources", metrics.Counter, nil},
+	}
+
+	for _, def := range defs {
+		m, err := registry.NewMetric(def.name, def.typ, def.valueType...)
+		if err != nil {
+			return nil, NewNatsError(1003, "failed to register metric "+def.name, err)
+		}
+		*def.metric = m
+	}
+
+	return set, nil
 }
 
-// NewNatsMetrics creates and registers all NATS metrics
-func NewNatsMetrics(vu VU) (*NatsMetrics, error) {
-	registry := metrics.NewRegistry()
+// NatsMetrics emits the NATS metrics for a single VU
+type NatsMetrics struct {
+	*natsMetricSet
+	vu modules.VU
+}
 
-	return &NatsMetrics{
-		registry: registry,
-		vu:       vu,
-	}, nil
+// NewNatsMetrics registers all NATS metrics in the registry of the VU's init
+// environment. It must be called from the init context.
+func NewNatsMetrics(vu modules.VU) (*NatsMetrics, error) {
+	env := vu.InitEnv()
+	if env == nil {
+		return nil, NewNatsError(1001, "metrics must be registered in the init context", nil)
+	}
+
+	set, err := registerMetrics(env.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NatsMetrics{natsMetricSet: set, vu: vu}, nil
 }
 
 // Registry returns the metrics registry
@@ -35,27 +143,215 @@ func (m *NatsMetrics) Registry() *metrics.Registry {
 	return m.registry
 }
 
-// Placeholder methods for metrics recording
-func (m *NatsMetrics) RecordConnectionEstablished()                                                 {}
-func (m *NatsMetrics) RecordConnectionClosed()                                                      {}
-func (m *NatsMetrics) RecordConnectionFailed()                                                      {}
-func (m *NatsMetrics) RecordReconnect()                                                             {}
-func (m *NatsMetrics) RecordMessagePublished(subject string, dataSize int64, latency time.Duration) {}
-func (m *NatsMetrics) RecordMessageReceived(subject string, dataSize int64, latency time.Duration)  {}
-func (m *NatsMetrics) RecordPublishError()                                                          {}
-func (m *NatsMetrics) RecordReceiveError()                                                          {}
-func (m *NatsMetrics) RecordRequestSent(dataSize int64)                                             {}
-func (m *NatsMetrics) RecordReplyReceived(dataSize int64, latency time.Duration)                    {}
-func (m *NatsMetrics) RecordRequestTimeout()                                                        {}
-func (m *NatsMetrics) RecordSubscriptionCreated()                                                   {}
-func (m *NatsMetrics) RecordSubscriptionClosed()                                                    {}
-func (m *NatsMetrics) RecordStreamMessageAdded()                                                    {}
-func (m *NatsMetrics) RecordStreamMessageDeleted()                                                  {}
-func (m *NatsMetrics) RecordConsumerMessageAcked()                                                  {}
-func (m *NatsMetrics) RecordConsumerMessageNacked()                                                 {}
-func (m *NatsMetrics) RecordConsumerRedelivery()                                                    {}
-
-// WrapConnection wraps a NATS connection to collect metrics
-func (m *NatsMetrics) WrapConnection(conn *nats.Conn) *nats.Conn {
-	return conn
+// push sends samples through the VU's sample channel. Samples are silently
+// dropped outside of the VU context, e.g. during init.
+func (m *NatsMetrics) push(tags map[string]string, values map[*metrics.Metric]float64) {
+	if m.vu == nil {
+		return
+	}
+	m.pushContext(m.vu.Context(), tags, values)
+}
+
+// pushContext is push with the samples dropped once ctx is done instead of
+// the VU context.
+func (m *NatsMetrics) pushContext(ctx context.Context, tags map[string]string, values map[*metrics.Metric]float64) {
+	if m.vu == nil {
+		return
+	}
+
+	state := m.vu.State()
+	if state == nil || state.Samples == nil {
+		return
+	}
+
+	tagSet := state.Tags.GetCurrentValues().Tags
+	for k, v := range tags {
+		tagSet = tagSet.With(k, v)
+	}
+
+	now := time.Now()
+	samples := make(metrics.Samples, 0, len(values))
+	for metric, value := range values {
+		samples = append(samples, metrics.Sample{
+			TimeSeries: metrics.TimeSeries{Metric: metric, Tags: tagSet},
+			Time:       now,
+			Value:      value,
+		})
+	}
+
+	metrics.PushIfNotDone(ctx, state.Samples, samples)
+}
+
+// RecordConnectionEstablished records a connection to server that took
+// latency to connect, including the TLS and protocol handshakes.
+func (m *NatsMetrics) RecordConnectionEstablished(server string, latency time.Duration) {
+	m.push(map[string]string{"server": server}, map[*metrics.Metric]float64{
+		m.Connections:     1,
+		m.ConnectDuration: metrics.D(latency),
+	})
+}
+
+func (m *NatsMetrics) RecordConnectionClosed() {
+	m.push(nil, map[*metrics.Metric]float64{m.ConnectionsClosed: 1})
+}
+
+// RecordConnectionFailed records a connect to server that failed, or a
+// connection that gave up reconnecting.
+func (m *NatsMetrics) RecordConnectionFailed(server string) {
+	m.push(map[string]string{"server": server}, map[*metrics.Metric]float64{m.ConnectionErrors: 1})
+}
+
+// RecordDisconnect records the loss of the connection to server.
+func (m *NatsMetrics) RecordDisconnect(server string) {
+	m.push(map[string]string{"server": server}, map[*metrics.Metric]float64{m.Disconnects: 1})
+}
+
+// RecordReconnect records a reconnect to server after an outage that lasted
+// downtime.
+func (m *NatsMetrics) RecordReconnect(server string, downtime time.Duration) {
+	m.push(map[string]string{"server": server}, map[*metrics.Metric]float64{
+		m.Reconnects:         1,
+		m.DisconnectDuration: metrics.D(downtime),
+	})
+}
+
+// RecordMessagePublished counts a published message. An empty subject, as for
+// replies to unique inboxes, is left out of the tags.
+func (m *NatsMetrics) RecordMessagePublished(subject string, dataSize int64, latency time.Duration) {
+	m.push(subjectTags(subject), map[*metrics.Metric]float64{
+		m.MsgsPublished:   1,
+		m.BytesPublished:  float64(dataSize),
+		m.PublishDuration: metrics.D(latency),
+	})
+}
+
+// RecordMessageReceived counts a delivered message. subject is the subject
+// subscribed to rather than that of the message, to keep wildcard
+// subscriptions to one tag value. A zero latency means the receive time is
+// unknown, e.g. for push deliveries.
+func (m *NatsMetrics) RecordMessageReceived(subject string, dataSize int64, latency time.Duration) {
+	values := map[*metrics.Metric]float64{
+		m.MsgsReceived:  1,
+		m.BytesReceived: float64(dataSize),
+	}
+	if latency > 0 {
+		values[m.ReceiveDuration] = metrics.D(latency)
+	}
+	m.push(subjectTags(subject), values)
+}
+
+// subjectTags tags samples with subject, unless it is empty.
+func subjectTags(subject string) map[string]string {
+	if subject == "" {
+		return nil
+	}
+	return map[string]string{"subject": subject}
+}
+
+func (m *NatsMetrics) RecordPublishError() {
+	m.push(nil, map[*metrics.Metric]float64{m.PublishErrors: 1})
+}
+
+func (m *NatsMetrics) RecordReceiveError() {
+	m.push(nil, map[*metrics.Metric]float64{m.ReceiveErrors: 1})
+}
+
+func (m *NatsMetrics) RecordMessageDropped() {
+	m.push(nil, map[*metrics.Metric]float64{m.MsgsDropped: 1})
+}
+
+// RecordSlowConsumer records that a subscription on subject fell behind and
+// nats.go started dropping its messages.
+func (m *NatsMetrics) RecordSlowConsumer(subject string) {
+	m.push(map[string]string{"subject": subject}, map[*metrics.Metric]float64{m.SlowConsumers: 1})
+}
+
+func (m *NatsMetrics) RecordRequestSent(dataSize int64) {
+	m.push(nil, map[*metrics.Metric]float64{
+		m.Requests:       1,
+		m.BytesPublished: float64(dataSize),
+	})
+}
+
+func (m *NatsMetrics) RecordReplyReceived(dataSize int64, latency time.Duration) {
+	m.push(nil, map[*metrics.Metric]float64{
+		m.Replies:         1,
+		m.BytesReceived:   float64(dataSize),
+		m.RequestDuration: metrics.D(latency),
+	})
+}
+
+func (m *NatsMetrics) RecordRequestTimeout() {
+	m.push(nil, map[*metrics.Metric]float64{m.RequestTimeouts: 1})
+}
+
+// RecordRequestAttempts records the number of attempts a request took, which
+// is more than one when it was retried. Its average is the retry
+// amplification.
+func (m *NatsMetrics) RecordRequestAttempts(attempts int) {
+	m.push(nil, map[*metrics.Metric]float64{
+		m.RequestAttempts: float64(attempts),
+		m.RequestRetries:  float64(attempts - 1),
+	})
+}
+
+// RecordRequestMany records the replies collected by a requestMany call, of
+// dataSize bytes in total, the last one received lastReply after sending.
+func (m *NatsMetrics) RecordRequestMany(replies int, dataSize int64, lastReply time.Duration) {
+	values := map[*metrics.Metric]float64{
+		m.Replies:            float64(replies),
+		m.BytesReceived:      float64(dataSize),
+		m.RequestManyReplies: float64(replies),
+	}
+	if replies > 0 {
+		values[m.RequestManyLastReply] = metrics.D(lastReply)
+	}
+	m.push(nil, values)
+}
+
+func (m *NatsMetrics) RecordSubscriptionCreated() {
+	active := m.activeSubscriptions.Add(1)
+	m.push(nil, map[*metrics.Metric]float64{m.SubscriptionsActive: float64(active)})
+}
+
+func (m *NatsMetrics) RecordSubscriptionClosed() {
+	active := m.activeSubscriptions.Add(-1)
+	m.push(nil, map[*metrics.Metric]float64{m.SubscriptionsActive: float64(active)})
+}
+
+func (m *NatsMetrics) RecordStreamMessageAdded() {
+	m.push(nil, map[*metrics.Metric]float64{m.StreamMsgsAdded: 1})
+}
+
+// RecordPublishAck records how long a stream took to acknowledge a publish.
+func (m *NatsMetrics) RecordPublishAck(subject string, latency time.Duration) {
+	m.push(map[string]string{"subject": subject}, map[*metrics.Metric]float64{
+		m.StreamAckDuration: metrics.D(latency),
+	})
+}
+
+func (m *NatsMetrics) RecordStreamMessageDeleted() {
+	m.push(nil, map[*metrics.Metric]float64{m.StreamMsgsDeleted: 1})
+}
+
+func (m *NatsMetrics) RecordConsumerMessageAcked() {
+	m.push(nil, map[*metrics.Metric]float64{m.ConsumerMsgsAcked: 1})
+}
+
+func (m *NatsMetrics) RecordConsumerMessageNacked() {
+	m.push(nil, map[*metrics.Metric]float64{m.ConsumerMsgsNacked: 1})
+}
+
+func (m *NatsMetrics) RecordConsumerRedelivery() {
+	m.push(nil, map[*metrics.Metric]float64{m.ConsumerRedelivered: 1})
+}
+
+// RecordLeakedResources counts resources of the given kind that were still
+// open at the end of an iteration. The iteration context is done by then, so
+// the samples are sent regardless of it; this must only be called while k6
+// still waits for the VU, i.e. from its IterEnd event.
+func (m *NatsMetrics) RecordLeakedResources(resource string, count int) {
+	m.pushContext(context.Background(), map[string]string{"resource": resource}, map[*metrics.Metric]float64{
+		m.LeakedResources: float64(count),
+	})
 }
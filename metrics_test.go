@@ -0,0 +1,145 @@
+package nats
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/metrics"
+)
+
+// collectSamples drains the samples pushed so far and sums them per metric.
+func collectSamples(samples <-chan metrics.SampleContainer) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, container := range metrics.GetBufferedSamples(samples) {
+		for _, sample := range container.GetSamples() {
+			totals[sample.Metric.Name] += sample.Value
+		}
+	}
+	return totals
+}
+
+func TestMetricsEmitted(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	responder, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer responder.Close()
+	_, err = responder.Subscribe("svc.echo", func(msg *nats.Msg) {
+		_ = msg.Respond(msg.Data)
+	})
+	require.NoError(t, err)
+	require.NoError(t, responder.Flush())
+
+	_, err = rt.VU.Runtime().RunString(`
+		const conn = nats.connect({ urls: [serverURL] });
+		conn.publish("metrics.subject", "12345");
+		conn.request("svc.echo", "ping", "2s");
+		conn.close();
+	`)
+	require.NoError(t, err)
+
+	totals := collectSamples(samples)
+	assert.Equal(t, 1.0, totals["nats_connections"])
+	assert.Equal(t, 1.0, totals["nats_connections_closed"])
+	assert.Equal(t, 1.0, totals["nats_msgs_published"])
+	assert.Equal(t, 9.0, totals["nats_bytes_published"])
+	assert.Equal(t, 1.0, totals["nats_requests"])
+	assert.Equal(t, 1.0, totals["nats_replies"])
+	assert.Contains(t, totals, "nats_request_duration")
+}
+
+func TestConnectionEventMetrics(t *testing.T) {
+	port := freePort(t)
+	s := runTestServerOnPort(t, port)
+	rt, samples := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+	require.NoError(t, runtime.Set("deadURL", "nats://127.0.0.1:"+strconv.Itoa(freePort(t))))
+
+	_, err := runtime.RunString(`
+		var conn = nats.connect({ urls: [serverURL], reconnectBackoff: ["50ms"] });
+		try {
+			nats.connect({ urls: [deadURL] });
+		} catch (e) {}
+	`)
+	require.NoError(t, err)
+	conn := runtime.Get("conn").Export().(*Connection)
+
+	s.Shutdown()
+	assert.Eventually(t, func() bool { return !conn.IsConnected() }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	runTestServerOnPort(t, port)
+	assert.Eventually(t, conn.IsConnected, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	totals := make(map[string]float64)
+	servers := make(map[string]string)
+	for _, container := range metrics.GetBufferedSamples(samples) {
+		for _, sample := range container.GetSamples() {
+			totals[sample.Metric.Name] += sample.Value
+			servers[sample.Metric.Name], _ = sample.Tags.Get("server")
+		}
+	}
+
+	assert.Equal(t, 1.0, totals["nats_connections"])
+	assert.Equal(t, 1.0, totals["nats_connection_errors"])
+	assert.Equal(t, 1.0, totals["nats_disconnects"])
+	assert.Equal(t, 1.0, totals["nats_reconnects"])
+	assert.Contains(t, totals, "nats_connect_duration")
+	assert.GreaterOrEqual(t, totals["nats_disconnected_duration"], 100.0)
+
+	assert.Equal(t, s.ClientURL(), servers["nats_connect_duration"])
+	assert.Equal(t, s.ClientURL(), servers["nats_disconnects"])
+	assert.Equal(t, s.ClientURL(), servers["nats_disconnected_duration"])
+	assert.Equal(t, runtime.Get("deadURL").String(), servers["nats_connection_errors"])
+}
+
+func TestReceivedMessagesTaggedWithSubscribedSubject(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const js = nats.jetStream(conn);
+		js.addStream(nats.streamConfig({
+			name: "TAGS",
+			subjects: ["tags.pull.>"],
+			storage: "memory",
+			replicas: 1,
+		}));
+
+		let received = 0;
+		const sub = conn.subscribe("tags.core.*", "", () => {
+			if (++received === 2) {
+				sub.unsubscribe();
+			}
+		});
+		conn.publish("tags.core.a", "one");
+		conn.publish("tags.core.b", "two");
+		conn.flush();
+
+		js.publish("tags.pull.a", "one");
+		js.publish("tags.pull.b", "two");
+		const pull = js.pullSubscribe("TAGS", "tags.pull.>", "worker");
+		js.pullMessages(pull, 2, "2s");
+	`)
+	require.NoError(t, err)
+
+	subjects := make(map[string]int)
+	for _, container := range metrics.GetBufferedSamples(samples) {
+		for _, sample := range container.GetSamples() {
+			if sample.Metric.Name == "nats_msgs_received" {
+				subject, _ := sample.Tags.Get("subject")
+				subjects[subject]++
+			}
+		}
+	}
+	assert.Equal(t, map[string]int{"tags.core.*": 2, "tags.pull.>": 2}, subjects)
+}
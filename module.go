@@ -2,136 +2,271 @@ package nats
 
 import (
 	"encoding/json"
+	"sync"
+	"sync/atomic"
+
 	"github.com/dop251/goja"
 	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/event"
+	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
 )
 
-type RootModule struct{}
+// importPath is the path scripts use to import the extension.
+const importPath = "k6/x/nats"
+
+func init() {
+	modules.Register(importPath, new(RootModule))
+}
+
+type RootModule struct {
+	// Metrics are registered once, by the first VU, and shared by all others.
+	metricsOnce sync.Once
+	metrics     *natsMetricSet
+	metricsErr  error
+
+	// Connection pools shared by all VUs, and those of each VU. All of them
+	// are closed on the TestEnd event.
+	pools        connPools
+	poolsMu      sync.Mutex
+	vuPools      []*connPools
+	poolsWatched bool
+}
+
+func (r *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	r.metricsOnce.Do(func() {
+		r.metrics, r.metricsErr = registerMetrics(vu.InitEnv().Registry)
+	})
+	if r.metricsErr != nil {
+		common.Throw(vu.Runtime(), r.metricsErr)
+	}
+
+	metrics := &NatsMetrics{natsMetricSet: r.metrics, vu: vu}
+	n := &NatsInstance{
+		vu:          vu,
+		metrics:     metrics,
+		resources:   newVUResources(vu, metrics),
+		globalPools: &r.pools,
+	}
+	r.trackPools(vu, &n.pools)
+
+	return n
+}
+
+// trackPools registers the pools of a VU to be closed at the end of the test.
+// The first VU with a global event system subscribes to TestEnd for all.
+func (r *RootModule) trackPools(vu modules.VU, pools *connPools) {
+	r.poolsMu.Lock()
+	defer r.poolsMu.Unlock()
+
+	r.vuPools = append(r.vuPools, pools)
+
+	global := vu.Events().Global
+	if r.poolsWatched || global == nil {
+		return
+	}
+	r.poolsWatched = true
+
+	_, events := global.Subscribe(event.TestEnd)
+	go func() {
+		for evt := range events {
+			r.closePools()
+			evt.Done()
+		}
+	}()
+}
+
+func (r *RootModule) closePools() {
+	r.poolsMu.Lock()
+	pools := append([]*connPools{&r.pools}, r.vuPools...)
+	r.poolsMu.Unlock()
 
-func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
-	return &NatsInstance{
-		vu: vu,
+	for _, p := range pools {
+		p.close()
 	}
 }
 
 type NatsInstance struct {
-	vu modules.VU
+	vu        modules.VU
+	metrics   *NatsMetrics
+	resources *vuResources
+
+	pools       connPools  // shared by the iterations of this VU
+	globalPools *connPools // shared by all VUs
 }
 
 func (n *NatsInstance) Exports() modules.Exports {
-	return modules.Exports{
-		Default: n,
-		Named: map[string]any{
-			"Connection":     n.NewConnection,
-			"JetStream":      n.NewJetStream,
-			"StreamConfig":   n.NewStreamConfig,
-			"ConsumerConfig": n.NewConsumerConfig,
-			"TLSOptions":     n.NewTLSOptions,
+	exports := map[string]any{
+		"connect":        n.ConnectFromJS,
+		"jetStream":      n.NewJetStream,
+		"streamConfig":   n.NewStreamConfig,
+		"consumerConfig": n.NewConsumerConfig,
+		"tlsOptions":     n.NewTLSOptions,
+		"Connection":     n.connectionClass,
+
+		"TLS_VERSIONS": map[string]string{
+			"TLS_1_0": "tlsv1.0",
+			"TLS_1_1": "tlsv1.1",
+			"TLS_1_2": "tlsv1.2",
+			"TLS_1_3": "tlsv1.3",
+		},
+		"RECONNECT_POLICIES": map[string]string{
+			"RECONNECT_POLICY_NONE":              ReconnectPolicyNone,
+			"RECONNECT_POLICY_RECONNECT":         ReconnectPolicyReconnect,
+			"RECONNECT_POLICY_RECONNECT_FOREVER": ReconnectPolicyForever,
+		},
+		"STORAGE_TYPES": map[string]string{
+			"STORAGE_TYPE_FILE":   "file",
+			"STORAGE_TYPE_MEMORY": "memory",
+		},
+		"RETENTION_POLICIES": map[string]string{
+			"RETENTION_POLICY_LIMITS":     "limits",
+			"RETENTION_POLICY_INTEREST":   "interest",
+			"RETENTION_POLICY_WORK_QUEUE": "workqueue",
+		},
+		"DISCARD_POLICIES": map[string]string{
+			"DISCARD_POLICY_OLD": "old",
+			"DISCARD_POLICY_NEW": "new",
+		},
+		"DELIVER_POLICIES": map[string]string{
+			"DELIVER_POLICY_ALL":               "all",
+			"DELIVER_POLICY_LAST":              "last",
+			"DELIVER_POLICY_NEW":               "new",
+			"DELIVER_POLICY_BY_START_SEQUENCE": "by_start_sequence",
+			"DELIVER_POLICY_BY_START_TIME":     "by_start_time",
+			"DELIVER_POLICY_LAST_PER_SUBJECT":  "last_per_subject",
+		},
+		"ACK_POLICIES": map[string]string{
+			"ACK_POLICY_NONE":     "none",
+			"ACK_POLICY_ALL":      "all",
+			"ACK_POLICY_EXPLICIT": "explicit",
+		},
+		"REPLAY_POLICIES": map[string]string{
+			"REPLAY_POLICY_INSTANT":  "instant",
+			"REPLAY_POLICY_ORIGINAL": "original",
 		},
 	}
+
+	// The same surface is exposed as the default export so that both
+	// `import nats from "k6/x/nats"` and named imports work.
+	return modules.Exports{
+		Default: exports,
+		Named:   exports,
+	}
 }
 
-func (n *NatsInstance) ConnectFromJS(opts goja.Value) *Connection {
+// ConnectFromJS connects with the options given by the script. Invalid
+// options and connection failures are thrown as NatsErrors.
+func (n *NatsInstance) ConnectFromJS(opts goja.Value) (_ *Connection, err error) {
+	defer convertError(n.vu, &err)
+
 	var connOpts ConnectionOptions
-	if opts != nil && !goja.IsUndefined(opts) && !goja.IsNull(opts) {
-		// Convert goja.Value to JSON then to struct
-		optsJSON, err := json.Marshal(opts.Export())
-		if err != nil {
-			n.vu.State().Logger.Errorf("Failed to marshal connection options: %v", err)
-			return nil
-		}
+	if err := parseJSOptions(opts, &connOpts); err != nil {
+		return nil, NewNatsError(1003, "failed to parse connection options", err)
+	}
 
-		if err := json.Unmarshal(optsJSON, &connOpts); err != nil {
-			n.vu.State().Logger.Errorf("Failed to parse connection options: %v", err)
-			return nil
-		}
+	return n.Connect(connOpts)
+}
+
+// parseJSOptions converts a JS options object into target through its JSON
+// representation. An undefined or null value leaves target untouched.
+func parseJSOptions(opts goja.Value, target any) error {
+	if opts == nil || goja.IsUndefined(opts) || goja.IsNull(opts) {
+		return nil
 	}
 
-	// Call the Connect method from connection.go
-	conn, err := (*NatsInstance)(n).Connect(connOpts)
+	optsJSON, err := json.Marshal(opts.Export())
 	if err != nil {
-		n.vu.State().Logger.Errorf("Failed to connect to NATS: %v", err)
-		return nil
+		return err
 	}
-	return conn
+
+	return json.Unmarshal(optsJSON, target)
 }
 
-func (n *NatsInstance) NewConnection(opts goja.Value) *Connection {
+func (n *NatsInstance) NewConnection(opts goja.Value) (*Connection, error) {
 	return n.ConnectFromJS(opts)
 }
 
-func (n *NatsInstance) NewJetStream(conn *Connection) *JetStream {
-	if conn == nil {
-		n.vu.State().Logger.Errorf("Connection cannot be nil")
-		return nil
+// connectionClass backs `new Connection(options)` in scripts.
+func (n *NatsInstance) connectionClass(call goja.ConstructorCall) *goja.Object {
+	rt := n.vu.Runtime()
+	conn, err := n.NewConnection(call.Argument(0))
+	if err != nil {
+		throwError(rt, err)
 	}
+	return rt.ToValue(conn).ToObject(rt)
+}
 
-	js, err := conn.JetStream()
-	if err != nil {
-		n.vu.State().Logger.Errorf("Failed to create JetStream context: %v", err)
-		return nil
+func (n *NatsInstance) NewJetStream(conn *Connection, opts goja.Value) (_ *JetStream, err error) {
+	defer convertError(n.vu, &err)
+
+	if conn == nil {
+		return nil, NewNatsError(1002, "connection cannot be nil", nil)
 	}
-	return js
+
+	return conn.JetStream(opts)
 }
 
-func (n *NatsInstance) NewStreamConfig(opts goja.Value) *StreamConfig {
-	var config StreamConfig
-	if opts != nil && !goja.IsUndefined(opts) && !goja.IsNull(opts) {
-		optsJSON, err := json.Marshal(opts.Export())
-		if err != nil {
-			n.vu.State().Logger.Errorf("Failed to marshal stream config: %v", err)
-			return nil
-		}
+func (n *NatsInstance) NewStreamConfig(opts goja.Value) (_ *StreamConfig, err error) {
+	defer convertError(n.vu, &err)
 
-		if err := json.Unmarshal(optsJSON, &config); err != nil {
-			n.vu.State().Logger.Errorf("Failed to parse stream config: %v", err)
-			return nil
-		}
+	var config StreamConfig
+	if err := parseJSOptions(opts, &config); err != nil {
+		return nil, NewNatsError(1003, "failed to parse stream config", err)
 	}
-	return &config
+	return &config, nil
 }
 
-func (n *NatsInstance) NewConsumerConfig(opts goja.Value) *ConsumerConfig {
-	var config ConsumerConfig
-	if opts != nil && !goja.IsUndefined(opts) && !goja.IsNull(opts) {
-		optsJSON, err := json.Marshal(opts.Export())
-		if err != nil {
-			n.vu.State().Logger.Errorf("Failed to marshal consumer config: %v", err)
-			return nil
-		}
+func (n *NatsInstance) NewConsumerConfig(opts goja.Value) (_ *ConsumerConfig, err error) {
+	defer convertError(n.vu, &err)
 
-		if err := json.Unmarshal(optsJSON, &config); err != nil {
-			n.vu.State().Logger.Errorf("Failed to parse consumer config: %v", err)
-			return nil
-		}
+	var config ConsumerConfig
+	if err := parseJSOptions(opts, &config); err != nil {
+		return nil, NewNatsError(1003, "failed to parse consumer config", err)
 	}
-	return &config
+	return &config, nil
 }
 
-func (n *NatsInstance) NewTLSOptions(opts goja.Value) *TLSOptions {
-	var tlsOpts TLSOptions
-	if opts != nil && !goja.IsUndefined(opts) && !goja.IsNull(opts) {
-		optsJSON, err := json.Marshal(opts.Export())
-		if err != nil {
-			n.vu.State().Logger.Errorf("Failed to marshal TLS options: %v", err)
-			return nil
-		}
+func (n *NatsInstance) NewTLSOptions(opts goja.Value) (_ *TLSOptions, err error) {
+	defer convertError(n.vu, &err)
 
-		if err := json.Unmarshal(optsJSON, &tlsOpts); err != nil {
-			n.vu.State().Logger.Errorf("Failed to parse TLS options: %v", err)
-			return nil
-		}
+	var tlsOpts TLSOptions
+	if err := parseJSOptions(opts, &tlsOpts); err != nil {
+		return nil, NewNatsError(1003, "failed to parse TLS options", err)
 	}
-	return &tlsOpts
+	return &tlsOpts, nil
 }
 
 type Connection struct {
-	vu modules.VU
-	nc *nats.Conn
+	vu        modules.VU
+	nc        *nats.Conn
+	metrics   *NatsMetrics
+	events    *connEvents
+	resources *vuResources
+
+	// Whether requests fail fast when nobody is subscribed
+	noResponders bool
+
+	// Handles on pooled connections leave closing to the pool, and only
+	// close the subscriptions made through them
+	pooled     bool
+	handleSubs *handleSubs
 }
 
 type JetStream struct {
-	vu modules.VU
-	js nats.JetStreamContext
+	vu        modules.VU
+	js        nats.JetStreamContext
+	metrics   *NatsMetrics
+	resources *vuResources
+
+	// Subscriptions of the pooled connection handle it was created from
+	handleSubs *handleSubs
+
+	// Outcome of the acks awaited by PublishAsync
+	asyncAcked  atomic.Uint64
+	asyncFailed atomic.Uint64
+
+	// Subject each pull subscription was made for, as the subject tag of
+	// fetched messages
+	pullSubjects sync.Map
 }
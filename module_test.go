@@ -0,0 +1,151 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+)
+
+// runTestServer starts an embedded NATS server with JetStream enabled.
+func runTestServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s
+}
+
+// newTestRuntime imports the module in the init context and then moves the
+// runtime into the VU context, like k6 does for a real VU. It returns the
+// channel that receives the VU's metric samples.
+func newTestRuntime(t *testing.T) (*modulestest.Runtime, chan metrics.SampleContainer) {
+	t.Helper()
+
+	return newTestRuntimeWithRoot(t, new(RootModule))
+}
+
+// newTestRuntimeWithRoot is newTestRuntime for VUs that share root.
+func newTestRuntimeWithRoot(t *testing.T, root *RootModule) (*modulestest.Runtime, chan metrics.SampleContainer) {
+	t.Helper()
+
+	return newTestRuntimeWithEvents(t, root, common.Events{})
+}
+
+// newTestRuntimeWithEvents is newTestRuntimeWithRoot for a VU with an event
+// system, which the module subscribes to on import.
+func newTestRuntimeWithEvents(
+	t *testing.T, root *RootModule, events common.Events,
+) (*modulestest.Runtime, chan metrics.SampleContainer) {
+	t.Helper()
+
+	rt := newTestInitRuntime(t, root, events)
+	return rt, moveToVUContext(t, rt)
+}
+
+// newTestInitRuntime imports the module and leaves the runtime in the init
+// context, for moveToVUContext once the init code ran.
+func newTestInitRuntime(t *testing.T, root *RootModule, events common.Events) *modulestest.Runtime {
+	t.Helper()
+
+	rt := modulestest.NewRuntime(t)
+	rt.VU.EventsField = events
+	require.NoError(t, rt.SetupModuleSystem(map[string]any{importPath: root}, nil, nil))
+
+	_, err := rt.VU.Runtime().RunString(`const nats = require("` + importPath + `");`)
+	require.NoError(t, err)
+
+	return rt
+}
+
+// moveToVUContext moves rt into the VU context and returns the channel that
+// receives the VU's metric samples.
+func moveToVUContext(t *testing.T, rt *modulestest.Runtime) chan metrics.SampleContainer {
+	t.Helper()
+
+	registry := metrics.NewRegistry()
+	samples := make(chan metrics.SampleContainer, 1000)
+	rt.MoveToVUContext(&lib.State{
+		Logger:         testutils.NewLogger(t),
+		BuiltinMetrics: metrics.RegisterBuiltinMetrics(registry),
+		Samples:        samples,
+		Tags:           lib.NewVUStateTags(registry.RootTagSet()),
+	})
+
+	return samples
+}
+
+func TestModuleExports(t *testing.T) {
+	rt, _ := newTestRuntime(t)
+
+	_, err := rt.VU.Runtime().RunString(`
+		for (const name of ["connect", "jetStream", "streamConfig", "consumerConfig", "tlsOptions", "Connection"]) {
+			if (typeof nats[name] !== "function") {
+				throw new Error(name + " is not exported");
+			}
+			if (typeof nats.default[name] !== "function") {
+				throw new Error(name + " is not part of the default export");
+			}
+		}
+		if (nats.STORAGE_TYPES.STORAGE_TYPE_MEMORY !== "memory") {
+			throw new Error("unexpected storage type constant");
+		}
+	`)
+	require.NoError(t, err)
+}
+
+func TestModuleEndToEnd(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.VU.Runtime().RunString(`
+		const conn = nats.connect({ urls: [serverURL] });
+		if (!conn.isConnected()) {
+			throw new Error("expected connection to be established");
+		}
+		conn.publish("test.subject", "hello");
+		conn.flush();
+
+		const js = nats.jetStream(conn);
+		js.addStream(nats.streamConfig({
+			name: "E2E",
+			subjects: ["e2e.>"],
+			storage: nats.STORAGE_TYPES.STORAGE_TYPE_MEMORY,
+			replicas: 1,
+		}));
+		js.publish("e2e.one", "payload");
+		const info = js.getStreamInfo("E2E");
+		if (info.state.msgs !== 1) {
+			throw new Error("expected 1 message in stream, got " + info.state.msgs);
+		}
+		conn.close();
+
+		const other = new nats.Connection({ urls: [serverURL] });
+		if (!other.isConnected()) {
+			throw new Error("expected Connection constructor to connect");
+		}
+		other.close();
+	`)
+	require.NoError(t, err)
+}
@@ -2,14 +2,32 @@ package nats
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib/types"
 )
 
+// urlSchemes maps the supported URL schemes to whether they use websockets.
+var urlSchemes = map[string]bool{
+	"nats": false,
+	"tls":  false,
+	"ws":   true,
+	"wss":  true,
+}
+
 func ValidateConnectionOptions(opts ConnectionOptions) error {
 	if len(opts.URLs) == 0 {
 		opts.URLs = []string{"nats://localhost:4222"}
 	}
 
+	if err := validateURLs(opts); err != nil {
+		return err
+	}
+
 	if opts.MaxReconnects < 0 {
 		return fmt.Errorf("maxReconnects must be non-negative")
 	}
@@ -26,18 +44,215 @@ func ValidateConnectionOptions(opts ConnectionOptions) error {
 		return fmt.Errorf("maxPingsOut must be non-negative")
 	}
 
+	if err := validateClientOptions(opts); err != nil {
+		return err
+	}
+
+	if err := validateReconnectOptions(opts); err != nil {
+		return err
+	}
+
+	if err := validateJWTAuth(opts); err != nil {
+		return err
+	}
+
+	if opts.Pool != nil {
+		if err := validatePoolOptions(opts.Pool); err != nil {
+			return err
+		}
+	}
+
 	if opts.TLS != nil {
-		if opts.TLS.CertFile != "" && opts.TLS.KeyFile == "" {
-			return fmt.Errorf("certFile specified but keyFile is missing")
+		if err := validateTLSOptions(opts.TLS); err != nil {
+			return err
 		}
-		if opts.TLS.KeyFile != "" && opts.TLS.CertFile == "" {
-			return fmt.Errorf("keyFile specified but certFile is missing")
+	}
+
+	return nil
+}
+
+func validateURLs(opts ConnectionOptions) error {
+	websockets := 0
+	plainWebsocket := ""
+	for _, raw := range opts.URLs {
+		u := raw
+		if !strings.Contains(u, "://") {
+			u = "nats://" + u
+		}
+
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid url %q: %w", raw, err)
+		}
+		websocket, ok := urlSchemes[parsed.Scheme]
+		if !ok {
+			return fmt.Errorf("unsupported scheme %q in url %q", parsed.Scheme, raw)
+		}
+		if parsed.Hostname() == "" {
+			return fmt.Errorf("url %q has no host", raw)
+		}
+		if websocket {
+			websockets++
+		}
+		if parsed.Scheme == "ws" {
+			plainWebsocket = raw
+		}
+	}
+
+	if websockets == 0 {
+		if opts.Compression {
+			return fmt.Errorf("compression requires ws:// or wss:// urls")
+		}
+		return nil
+	}
+	if websockets < len(opts.URLs) {
+		return fmt.Errorf("websocket and non-websocket urls cannot be mixed")
+	}
+
+	if opts.TLS != nil {
+		if plainWebsocket != "" {
+			return fmt.Errorf("tls options require wss:// instead of %q", plainWebsocket)
+		}
+		if opts.TLS.HandshakeFirst {
+			return fmt.Errorf("handshakeFirst is not supported with websocket urls")
+		}
+	}
+
+	return nil
+}
+
+func validateClientOptions(opts ConnectionOptions) error {
+	if strings.ContainsAny(opts.InboxPrefix, "*> \t") || strings.HasSuffix(opts.InboxPrefix, ".") {
+		return fmt.Errorf("inboxPrefix %q must be a subject without wildcards or a trailing dot", opts.InboxPrefix)
+	}
+
+	if opts.ConnectTimeout < 0 {
+		return fmt.Errorf("connectTimeout must be non-negative")
+	}
+
+	if opts.FlusherTimeout < 0 {
+		return fmt.Errorf("flusherTimeout must be non-negative")
+	}
+
+	if opts.DrainTimeout < 0 {
+		return fmt.Errorf("drainTimeout must be non-negative")
+	}
+
+	return nil
+}
+
+func validateReconnectOptions(opts ConnectionOptions) error {
+	switch opts.ReconnectPolicy {
+	case "", ReconnectPolicyNone, ReconnectPolicyReconnect, ReconnectPolicyForever:
+	default:
+		return fmt.Errorf("unsupported reconnectPolicy %q", opts.ReconnectPolicy)
+	}
+
+	if opts.AllowReconnect != nil && !*opts.AllowReconnect &&
+		opts.ReconnectPolicy != "" && opts.ReconnectPolicy != ReconnectPolicyNone {
+		return fmt.Errorf("allowReconnect is false but reconnectPolicy is %q", opts.ReconnectPolicy)
+	}
+
+	if opts.ReconnectJitter < 0 {
+		return fmt.Errorf("reconnectJitter must be non-negative")
+	}
+
+	if opts.ReconnectJitterTLS < 0 {
+		return fmt.Errorf("reconnectJitterTLS must be non-negative")
+	}
+
+	for i, backoff := range opts.ReconnectBackoff {
+		if backoff < 0 {
+			return fmt.Errorf("reconnectBackoff[%d] must be non-negative", i)
+		}
+	}
+
+	if opts.ReconnectBufSize < -1 {
+		return fmt.Errorf("reconnectBufSize must be -1 or greater")
+	}
+
+	return nil
+}
+
+func validatePoolOptions(opts *PoolOptions) error {
+	switch opts.Scope {
+	case "", PoolScopeVU, PoolScopeGlobal:
+	default:
+		return fmt.Errorf("unsupported pool scope %q", opts.Scope)
+	}
+
+	if opts.Size < 0 {
+		return fmt.Errorf("pool size must be non-negative")
+	}
+
+	return nil
+}
+
+func validateJWTAuth(opts ConnectionOptions) error {
+	methods := 0
+	for _, set := range []bool{
+		opts.CredsFile != "",
+		opts.Creds != "",
+		opts.JWT != "" || opts.Seed != "",
+		opts.NkeySeed != "",
+		opts.NkeyFile != "",
+	} {
+		if set {
+			methods++
 		}
 	}
+	if methods > 1 {
+		return fmt.Errorf("only one of credsFile, creds, jwt, nkeySeed and nkeyFile can be set")
+	}
+
+	if opts.JWT != "" && opts.Seed == "" {
+		return fmt.Errorf("jwt specified but seed is missing")
+	}
+	if opts.Seed != "" && opts.JWT == "" {
+		return fmt.Errorf("seed specified but jwt is missing")
+	}
 
 	return nil
 }
 
+func validateTLSOptions(opts *TLSOptions) error {
+	if opts.CertFile != "" && opts.Cert != "" {
+		return fmt.Errorf("certFile and cert are mutually exclusive")
+	}
+	if opts.KeyFile != "" && opts.Key != "" {
+		return fmt.Errorf("keyFile and key are mutually exclusive")
+	}
+	if opts.CAFile != "" && opts.CA != "" {
+		return fmt.Errorf("caFile and ca are mutually exclusive")
+	}
+
+	hasCert := opts.CertFile != "" || opts.Cert != ""
+	hasKey := opts.KeyFile != "" || opts.Key != ""
+	if hasCert && !hasKey {
+		return fmt.Errorf("certificate specified but key is missing")
+	}
+	if hasKey && !hasCert {
+		return fmt.Errorf("key specified but certificate is missing")
+	}
+
+	if _, ok := tlsVersions[opts.MinVersion]; opts.MinVersion != "" && !ok {
+		return fmt.Errorf("unsupported minVersion %q", opts.MinVersion)
+	}
+
+	return nil
+}
+
+// warnRetentionDuration logs a warning for a maxAge or ackWait below a
+// second. Plain numbers used to be seconds and are milliseconds now, so such
+// a value may come from a script written for the old unit.
+func warnRetentionDuration(vu modules.VU, name string, d types.Duration) {
+	state := vu.State()
+	if state == nil || d <= 0 || time.Duration(d) >= time.Second {
+		return
+	}
+	state.Logger.Warnf("NATS: %s is %s; numbers are milliseconds, use e.g. '30s' for seconds", name, d)
+}
+
 func ValidateStreamConfig(config StreamConfig) error {
 	if config.Name == "" {
 		return fmt.Errorf("stream name is required")
@@ -88,6 +303,103 @@ func ValidateConsumerConfig(config ConsumerConfig) error {
 	return nil
 }
 
+func ValidateSubscribeOptions(opts SubscribeOptions) error {
+	if opts.QueueSize < 0 {
+		return fmt.Errorf("queueSize must be non-negative")
+	}
+
+	switch opts.Overflow {
+	case "", OverflowDrop, OverflowBlock:
+	default:
+		return fmt.Errorf("overflow must be %q or %q", OverflowDrop, OverflowBlock)
+	}
+
+	return nil
+}
+
+func ValidateRequestManyOptions(opts RequestManyOptions) error {
+	if opts.MaxMessages < 0 {
+		return fmt.Errorf("maxMessages must be non-negative")
+	}
+
+	if opts.MaxWait < 0 {
+		return fmt.Errorf("maxWait must be non-negative")
+	}
+
+	if opts.StallWait < 0 {
+		return fmt.Errorf("stallWait must be non-negative")
+	}
+
+	return nil
+}
+
+func ValidateRetryPolicy(policy RetryPolicy) error {
+	if policy.Attempts < 0 {
+		return fmt.Errorf("attempts must be non-negative")
+	}
+
+	for i, backoff := range policy.Backoff {
+		if backoff < 0 {
+			return fmt.Errorf("backoff[%d] must be non-negative", i)
+		}
+	}
+
+	for _, code := range policy.RetryOn {
+		if code < 1001 {
+			return fmt.Errorf("retryOn contains unknown error code %d", code)
+		}
+	}
+
+	return nil
+}
+
+func ValidateExpectOptions(opts ExpectOptions) error {
+	if opts.Count < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+
+	if opts.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative")
+	}
+
+	return nil
+}
+
+func ValidateJetStreamOptions(opts JetStreamOptions) error {
+	if opts.PublishAsyncMaxPending < 0 {
+		return fmt.Errorf("publishAsyncMaxPending must be non-negative")
+	}
+
+	return nil
+}
+
+func ValidatePublishOptions(opts PublishOptions) error {
+	if opts.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative")
+	}
+
+	return nil
+}
+
+// parseTimeout reads a duration argument given in milliseconds or as a k6
+// duration string such as "250ms" or "1.5s". Undefined and null yield zero,
+// which callers treat as their default.
+func parseTimeout(v goja.Value) (time.Duration, error) {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return 0, nil
+	}
+
+	timeout, err := types.GetDurationValue(v.Export())
+	if err != nil {
+		return 0, NewNatsError(1003, "invalid timeout", err)
+	}
+	if timeout < 0 {
+		return 0, NewNatsError(1003, "invalid timeout", fmt.Errorf("timeout must be non-negative"))
+	}
+
+	return timeout, nil
+}
+
 func ParseDuration(seconds int) time.Duration {
 	return time.Duration(seconds) * time.Second
 }
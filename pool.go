@@ -0,0 +1,259 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+	"go.k6.io/k6/lib"
+)
+
+const (
+	// PoolScopeVU shares connections between the iterations of a VU.
+	PoolScopeVU = "vu"
+	// PoolScopeGlobal shares connections between all VUs.
+	PoolScopeGlobal = "global"
+)
+
+// PoolOptions makes connect() hand out a connection from a pool instead of
+// opening a new one.
+type PoolOptions struct {
+	Scope string `js:"scope"` // PoolScopeVU by default
+	Size  int    `js:"size"`  // 1 by default
+}
+
+// connPools are the pools of one scope, keyed by the options they were
+// created with.
+type connPools struct {
+	mu    sync.Mutex
+	pools map[string]*connPool
+}
+
+func (p *connPools) get(key string, size int) *connPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pools == nil {
+		p.pools = make(map[string]*connPool)
+	}
+	pool, ok := p.pools[key]
+	if !ok {
+		pool = &connPool{
+			conns: make([]*Connection, size),
+			users: make(map[modules.VU]struct{}),
+		}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// close closes the connections of every pool.
+func (p *connPools) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pool := range p.pools {
+		pool.close()
+	}
+}
+
+// connPool hands out its connections round-robin. Connections are opened on
+// first use and reopened when they were closed, e.g. after the reconnect
+// attempts ran out. They are closed at the end of the test, as the VU
+// context only lasts for one iteration.
+type connPool struct {
+	mu    sync.Mutex
+	conns []*Connection
+	next  int
+
+	// The VUs using the pool. usersMu is taken after mu, as connections are
+	// dialed with mu held and look up the active VU.
+	usersMu sync.Mutex
+	users   map[modules.VU]struct{}
+}
+
+func (p *connPool) take(dial func() (*Connection, error)) (*Connection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.next
+	p.next = (p.next + 1) % len(p.conns)
+
+	conn := p.conns[i]
+	if conn == nil || conn.nc.IsClosed() {
+		var err error
+		if conn, err = dial(); err != nil {
+			return nil, err
+		}
+		p.conns[i] = conn
+	}
+	return conn, nil
+}
+
+// attach records vu as a user of the pool.
+func (p *connPool) attach(vu modules.VU) {
+	p.usersMu.Lock()
+	defer p.usersMu.Unlock()
+
+	p.users[vu] = struct{}{}
+}
+
+// activeVU returns a VU of the pool that is running an iteration, or nil.
+func (p *connPool) activeVU() modules.VU {
+	p.usersMu.Lock()
+	defer p.usersMu.Unlock()
+
+	for vu := range p.users {
+		if ctx := vu.Context(); ctx != nil && ctx.Err() == nil {
+			return vu
+		}
+	}
+	return nil
+}
+
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, conn := range p.conns {
+		if conn != nil {
+			conn.close()
+			p.conns[i] = nil
+		}
+	}
+}
+
+// connectPooled returns a handle on a pooled connection for opts, which have
+// been validated. Each VU gets its own handle, so that messages are handled
+// on its own event loop.
+func (n *NatsInstance) connectPooled(opts ConnectionOptions) (*Connection, error) {
+	// The handles run their handlers on the event loop of an iteration
+	if n.vu.State() == nil {
+		return nil, NewNatsError(1001, "pooled connections can only be opened in the VU context", nil)
+	}
+
+	key, err := json.Marshal(opts)
+	if err != nil {
+		return nil, NewNatsError(1003, "invalid connection options", err)
+	}
+
+	pools := &n.pools
+	if opts.Pool.Scope == PoolScopeGlobal {
+		pools = n.globalPools
+	}
+	size := opts.Pool.Size
+	if size == 0 {
+		size = 1
+	}
+	pool := pools.get(string(key), size)
+
+	// Pooled connections outlive the call, so they do not take the pool
+	// options into account. Their events are recorded through whichever VU
+	// still uses the pool.
+	dialOpts := opts
+	dialOpts.Pool = nil
+	conn, err := pool.take(func() (*Connection, error) {
+		vu := &poolVU{VU: n.vu, pool: pool}
+		dialer := &NatsInstance{
+			vu:        vu,
+			metrics:   &NatsMetrics{natsMetricSet: n.metrics.natsMetricSet, vu: vu},
+			resources: n.resources,
+		}
+		return dialer.connect(dialOpts)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pool.attach(n.vu)
+
+	return &Connection{
+		vu:           n.vu,
+		nc:           conn.nc,
+		metrics:      n.metrics,
+		resources:    n.resources,
+		noResponders: conn.noResponders,
+		pooled:       true,
+		handleSubs:   new(handleSubs),
+	}, nil
+}
+
+// poolVU is the VU the events of a pooled connection, such as reconnects or
+// slow consumers, are recorded through. A global pool is shared by all VUs,
+// so it forwards to one that is running an iteration rather than to the VU
+// that happened to open the connection, which may be done long before the
+// others.
+// k6 sends the samples of every VU to the same channel, so it does not
+// matter which one it is.
+type poolVU struct {
+	modules.VU // the VU that opened the connection
+
+	pool *connPool
+}
+
+func (v *poolVU) active() modules.VU {
+	if vu := v.pool.activeVU(); vu != nil {
+		return vu
+	}
+	return v.VU
+}
+
+func (v *poolVU) Context() context.Context {
+	return v.active().Context()
+}
+
+func (v *poolVU) State() *lib.State {
+	return v.active().State()
+}
+
+// handleSubsPruneSize is the number of tracked subscriptions from which
+// closed ones are pruned on every new one.
+const handleSubsPruneSize = 64
+
+// handleSubs are the subscriptions made through a handle on a pooled
+// connection. Closing the handle leaves the connection open, so it
+// unsubscribes them instead. A nil *handleSubs, as non-pooled connections
+// have, tracks nothing.
+type handleSubs struct {
+	mu   sync.Mutex
+	subs map[*nats.Subscription]struct{}
+}
+
+func (h *handleSubs) add(sub *nats.Subscription) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[*nats.Subscription]struct{})
+	}
+	if len(h.subs) >= handleSubsPruneSize {
+		for tracked := range h.subs {
+			if !tracked.IsValid() {
+				delete(h.subs, tracked)
+			}
+		}
+	}
+	h.subs[sub] = struct{}{}
+}
+
+// unsubscribe closes the subscriptions that are still open.
+func (h *handleSubs) unsubscribe() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	subs := h.subs
+	h.subs = nil
+	h.mu.Unlock()
+
+	for sub := range subs {
+		if sub.IsValid() {
+			_ = sub.Unsubscribe()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/event"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib/testutils"
+)
+
+// emitTestEnd emits TestEnd like k6 does after the last iteration.
+func emitTestEnd(t *testing.T, global *event.System) {
+	t.Helper()
+
+	wait := global.Emit(&event.Event{Type: event.TestEnd})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, wait(ctx))
+}
+
+func TestConnectionPoolPerVU(t *testing.T) {
+	s := runTestServer(t)
+	global := event.NewEventSystem(10, testutils.NewLogger(t))
+	rt, _ := newTestRuntimeWithEvents(t, new(RootModule), common.Events{Global: global})
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+
+	_, err := runtime.RunString(`
+		var opts = { urls: [serverURL], pool: { size: 2 } };
+		var first = nats.connect(opts);
+		first.close();
+		var second = nats.connect(opts);
+		var third = nats.connect(opts);
+		var other = nats.connect({ urls: [serverURL], name: "other", pool: {} });
+
+		var handlerRejected = false;
+		try {
+			third.onDisconnect(function () {});
+		} catch (e) {
+			handlerRejected = e.code === 1003;
+		}
+
+		third.publish("pool.test", "still open");
+		third.flush();
+	`)
+	require.NoError(t, err)
+
+	conn := func(name string) *Connection {
+		return runtime.Get(name).Export().(*Connection)
+	}
+	first, second, third, other := conn("first"), conn("second"), conn("third"), conn("other")
+
+	// Round-robin over two connections, close() keeps them open
+	assert.NotSame(t, first.nc, second.nc)
+	assert.Same(t, first.nc, third.nc)
+	assert.NotSame(t, first.nc, other.nc)
+	assert.True(t, first.nc.IsConnected())
+	assert.True(t, runtime.Get("handlerRejected").ToBoolean())
+
+	emitTestEnd(t, global)
+	for _, c := range []*Connection{first, second, other} {
+		assert.True(t, c.nc.IsClosed())
+	}
+}
+
+func TestConnectionPoolAcrossIterations(t *testing.T) {
+	s := runTestServer(t)
+	global := event.NewEventSystem(10, testutils.NewLogger(t))
+	rt, _ := newTestRuntimeWithEvents(t, new(RootModule), common.Events{Global: global})
+
+	script := `nats.connect({ urls: ["` + s.ClientURL() + `"], pool: {} })`
+	v, err := rt.VU.Runtime().RunString(script)
+	require.NoError(t, err)
+	first := v.Export().(*Connection)
+
+	// k6 gives every iteration a new context
+	rt.CancelContext()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rt.VU.CtxField = ctx
+	time.Sleep(50 * time.Millisecond)
+
+	v, err = rt.VU.Runtime().RunString(script)
+	require.NoError(t, err)
+	second := v.Export().(*Connection)
+
+	assert.Same(t, first.nc, second.nc)
+	assert.True(t, second.nc.IsConnected())
+
+	emitTestEnd(t, global)
+	assert.True(t, second.nc.IsClosed())
+}
+
+func TestConnectionPoolGlobal(t *testing.T) {
+	s := runTestServer(t)
+	root := new(RootModule)
+	global := event.NewEventSystem(10, testutils.NewLogger(t))
+
+	vu1, _ := newTestRuntimeWithEvents(t, root, common.Events{Global: global})
+	vu2, _ := newTestRuntimeWithEvents(t, root, common.Events{Global: global})
+
+	var conns []*Connection
+	script := `nats.connect({ urls: ["` + s.ClientURL() + `"], pool: { scope: "global" } })`
+	for _, vu := range []*modulestest.Runtime{vu1, vu2} {
+		v, err := vu.VU.Runtime().RunString(script)
+		require.NoError(t, err)
+		conns = append(conns, v.Export().(*Connection))
+	}
+
+	// Both VUs share the connection through their own handles
+	assert.Same(t, conns[0].nc, conns[1].nc)
+	assert.Equal(t, vu2.VU, conns[1].vu)
+
+	// The connection stays open until the test ends
+	vu1.CancelContext()
+	vu2.CancelContext()
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, conns[0].nc.IsConnected())
+
+	emitTestEnd(t, global)
+	assert.True(t, conns[0].nc.IsClosed())
+}
+
+func TestConnectionPoolGlobalEvents(t *testing.T) {
+	s := runTestServer(t)
+	root := new(RootModule)
+
+	vu1, _ := newTestRuntimeWithRoot(t, root)
+	vu2, samples := newTestRuntimeWithRoot(t, root)
+	require.NoError(t, vu2.VU.Runtime().Set("sleep", func(ms int) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}))
+
+	script := `nats.connect({ urls: ["` + s.ClientURL() + `"], pool: { scope: "global" } })`
+	for _, vu := range []*modulestest.Runtime{vu1, vu2} {
+		_, err := vu.VU.Runtime().RunString(script)
+		require.NoError(t, err)
+	}
+
+	// The VU that opened the connection is done, the other one keeps using it
+	vu1.CancelContext()
+	_, err := vu2.RunOnEventLoop(`
+		const conn = ` + script + `;
+		const slow = conn.subscribe("pool.slow", "", () => {}, { queueSize: 1, overflow: "block" });
+		slow.setPendingLimits(2, -1);
+		for (let i = 0; i < 20; i++) {
+			conn.publish("pool.slow", "payload");
+		}
+		conn.flush();
+		sleep(200);
+		slow.unsubscribe();
+	`)
+	require.NoError(t, err)
+
+	slowConsumers := 0.0
+	assert.Eventually(t, func() bool {
+		slowConsumers += collectSamples(samples)["nats_slow_consumers"]
+		return slowConsumers > 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestConnectionPoolCloseUnsubscribes(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rt.RunOnEventLoop(`
+			const conn = nats.connect({ urls: [serverURL], pool: { scope: "vu" } });
+			const sub = conn.subscribe("pool.handler", "", () => {});
+			const syncSub = conn.subscribeSync("pool.sync");
+			conn.close();
+		`)
+		done <- err
+	}()
+
+	// The handler subscription must not keep the iteration alive
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("iteration did not end after closing the pooled connection")
+	}
+
+	runtime := rt.VU.Runtime()
+	conn := runtime.Get("conn").Export().(*Connection)
+	assert.True(t, conn.nc.IsConnected())
+	assert.False(t, runtime.Get("sub").Export().(*Subscription).IsValid())
+	assert.False(t, runtime.Get("syncSub").Export().(*SyncSubscription).IsValid())
+
+	assert.Eventually(t, func() bool {
+		conn.resources.mu.Lock()
+		defer conn.resources.mu.Unlock()
+		return len(conn.resources.subs) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
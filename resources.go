@@ -0,0 +1,241 @@
+package nats
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/event"
+	"go.k6.io/k6/js/modules"
+)
+
+// Resource kinds, used as the resource tag of nats_leaked_resources.
+const (
+	resourceConnection   = "connection"
+	resourceSubscription = "subscription"
+	resourceJetStream    = "jetstream"
+)
+
+// vuResources tracks the connections, subscriptions and JetStream contexts
+// a VU created. Whatever is still open at the end of the iteration, e.g.
+// because it was interrupted before it called close(), is counted as leaked
+// and drained.
+//
+// The cleanup runs on the VU's IterEnd event, which k6 waits for before it
+// lets the VU go, so the leak metrics are pushed before the run ends and
+// closes the samples channel. Without an event system, as in tests, it runs
+// once the VU context is done instead.
+//
+// Resources created in the init context are meant to live as long as the VU,
+// so they are not tracked.
+//
+// Closed connections and async subscriptions remove themselves from their
+// nats.go closed handlers. nats.go calls no closed handler for synchronous
+// and pull subscriptions, so those are removed with closeSub. JetStream
+// contexts hold no resources besides their pending async publishes, so they
+// are pruned once those are acked.
+type vuResources struct {
+	vu      modules.VU
+	metrics *NatsMetrics
+
+	mu        sync.Mutex
+	conns     map[*nats.Conn]*Connection
+	subs      map[*nats.Subscription]struct{}
+	jetStream map[*JetStream]struct{}
+	watched   context.Context
+
+	// Whether cleanup runs on IterEnd events rather than by watch
+	onIterEnd bool
+}
+
+// jetStreamPruneSize is the number of tracked JetStream contexts from which
+// acked ones are pruned on every new one.
+const jetStreamPruneSize = 64
+
+// newVUResources must be called from NewModuleInstance, where VU events can
+// be subscribed to.
+func newVUResources(vu modules.VU, metrics *NatsMetrics) *vuResources {
+	r := &vuResources{
+		vu:        vu,
+		metrics:   metrics,
+		conns:     make(map[*nats.Conn]*Connection),
+		subs:      make(map[*nats.Subscription]struct{}),
+		jetStream: make(map[*JetStream]struct{}),
+	}
+
+	if local := vu.Events().Local; local != nil {
+		r.onIterEnd = true
+		_, events := local.Subscribe(event.IterEnd)
+		go func() {
+			for evt := range events {
+				r.cleanup()
+				evt.Done()
+			}
+		}()
+	}
+
+	return r
+}
+
+func (r *vuResources) addConn(c *Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.nc.IsClosed() || r.vu.State() == nil {
+		return
+	}
+	r.conns[c.nc] = c
+	r.watch()
+}
+
+func (r *vuResources) removeConn(nc *nats.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, nc)
+}
+
+func (r *vuResources) addSub(sub *nats.Subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !sub.IsValid() || r.vu.State() == nil {
+		return
+	}
+	r.subs[sub] = struct{}{}
+	r.watch()
+}
+
+func (r *vuResources) removeSub(sub *nats.Subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.subs, sub)
+}
+
+// closeSub does the bookkeeping of a closed synchronous or pull subscription,
+// which nats.go leaves to the closed handler of async ones. It is safe to call
+// more than once.
+func (r *vuResources) closeSub(sub *nats.Subscription) {
+	r.mu.Lock()
+	_, tracked := r.subs[sub]
+	delete(r.subs, sub)
+	r.mu.Unlock()
+
+	if tracked {
+		r.metrics.RecordSubscriptionClosed()
+	}
+}
+
+// closeInvalidSubs calls closeSub for the synchronous and pull subscriptions
+// that are no longer valid, e.g. because their connection was closed.
+func (r *vuResources) closeInvalidSubs() {
+	r.mu.Lock()
+	var closed []*nats.Subscription
+	for sub := range r.subs {
+		if sub.Type() != nats.AsyncSubscription && !sub.IsValid() {
+			closed = append(closed, sub)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, sub := range closed {
+		r.closeSub(sub)
+	}
+}
+
+func (r *vuResources) addJetStream(j *JetStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.vu.State() == nil {
+		return
+	}
+	if len(r.jetStream) >= jetStreamPruneSize {
+		for tracked := range r.jetStream {
+			if tracked.js.PublishAsyncPending() == 0 {
+				delete(r.jetStream, tracked)
+			}
+		}
+	}
+	r.jetStream[j] = struct{}{}
+	r.watch()
+}
+
+// watch starts the cleanup for the current VU context, unless it is already
+// waiting for it or the cleanup runs on IterEnd events. It must be called
+// with r.mu held.
+func (r *vuResources) watch() {
+	ctx := r.vu.Context()
+	if r.onIterEnd || ctx == nil || ctx == r.watched {
+		return
+	}
+	r.watched = ctx
+
+	go func() {
+		<-ctx.Done()
+		r.cleanup()
+	}()
+}
+
+// cleanup drains everything that is still open and counts it as leaked.
+// Subscriptions go first, as those on pooled connections are not drained
+// with their connection.
+func (r *vuResources) cleanup() {
+	r.mu.Lock()
+	r.watched = nil
+	subs, conns, jetStream := r.subs, r.conns, r.jetStream
+	r.subs = make(map[*nats.Subscription]struct{})
+	r.conns = make(map[*nats.Conn]*Connection)
+	r.jetStream = make(map[*JetStream]struct{})
+	r.mu.Unlock()
+
+	pending := 0
+	for j := range jetStream {
+		if j.js.PublishAsyncPending() > 0 {
+			pending++
+		}
+	}
+
+	leakedSubs := 0
+	for sub := range subs {
+		if sub.IsValid() {
+			leakedSubs++
+			if err := sub.Drain(); err != nil {
+				_ = sub.Unsubscribe()
+			}
+		}
+		// Async subscriptions are counted as closed by their closed handler
+		if sub.Type() != nats.AsyncSubscription {
+			r.metrics.RecordSubscriptionClosed()
+		}
+	}
+
+	leakedConns := 0
+	for nc, c := range conns {
+		if nc.IsClosed() {
+			continue
+		}
+		leakedConns++
+		if c.events != nil {
+			c.events.closing.Store(true)
+		}
+		if err := nc.Drain(); err != nil {
+			nc.Close()
+		}
+	}
+
+	for kind, count := range map[string]int{
+		resourceConnection:   leakedConns,
+		resourceSubscription: leakedSubs,
+		resourceJetStream:    pending,
+	} {
+		if count == 0 {
+			continue
+		}
+		r.metrics.RecordLeakedResources(kind, count)
+		if state := r.vu.State(); state != nil {
+			state.Logger.Warnf("NATS: cleaned up %d %s(s) left open at the end of the VU", count, kind)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/event"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib/testutils"
+	"go.k6.io/k6/metrics"
+)
+
+func TestVUResourcesCleanup(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	runtime := rt.VU.Runtime()
+	require.NoError(t, runtime.Set("serverURL", s.ClientURL()))
+
+	_, err := runtime.RunString(`
+		var leaked = nats.connect({ urls: [serverURL] });
+		var sub = leaked.subscribe("cleanup.test", "", function () {});
+		var js = leaked.jetStream();
+
+		var closed = nats.connect({ urls: [serverURL] });
+		closed.subscribe("cleanup.closed", "", function () {});
+		closed.close();
+	`)
+	require.NoError(t, err)
+
+	leaked := runtime.Get("leaked").Export().(*Connection)
+	closed := runtime.Get("closed").Export().(*Connection)
+	assert.Eventually(t, func() bool {
+		leaked.resources.mu.Lock()
+		defer leaked.resources.mu.Unlock()
+		_, tracked := leaked.resources.conns[closed.nc]
+		return !tracked
+	}, 2*time.Second, 10*time.Millisecond, "closed connections should not be tracked")
+
+	rt.CancelContext()
+	assert.Eventually(t, leaked.nc.IsClosed, 5*time.Second, 10*time.Millisecond)
+
+	leakedByKind := make(map[string]float64)
+	assert.Eventually(t, func() bool {
+		for _, container := range metrics.GetBufferedSamples(samples) {
+			for _, sample := range container.GetSamples() {
+				if sample.Metric.Name == "nats_leaked_resources" {
+					kind, _ := sample.Tags.Get("resource")
+					leakedByKind[kind] += sample.Value
+				}
+			}
+		}
+		return len(leakedByKind) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, map[string]float64{
+		resourceConnection:   1,
+		resourceSubscription: 1,
+	}, leakedByKind)
+}
+
+func TestVUResourcesCleanupOnIterEnd(t *testing.T) {
+	s := runTestServer(t)
+	local := event.NewEventSystem(10, testutils.NewLogger(t))
+	rt, samples := newTestRuntimeWithEvents(t, new(RootModule), common.Events{Local: local})
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	v, err := rt.VU.Runtime().RunString(`nats.connect({ urls: [serverURL] })`)
+	require.NoError(t, err)
+	leaked := v.Export().(*Connection)
+
+	// The iteration context ends before k6 emits IterEnd
+	rt.CancelContext()
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, leaked.nc.IsClosed())
+
+	wait := local.Emit(&event.Event{Type: event.IterEnd, Data: event.IterData{}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, wait(ctx))
+
+	// The leak is recorded by the time k6 would let the VU go
+	assert.Equal(t, 1.0, collectSamples(samples)["nats_leaked_resources"])
+	assert.Eventually(t, leaked.nc.IsClosed, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestVUResourcesInitContextSurvivesIterEnd(t *testing.T) {
+	s := runTestServer(t)
+	local := event.NewEventSystem(10, testutils.NewLogger(t))
+	rt := newTestInitRuntime(t, new(RootModule), common.Events{Local: local})
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	// Made once per VU in the init context, to be used by every iteration
+	v, err := rt.VU.Runtime().RunString(`nats.connect({ urls: [serverURL] })`)
+	require.NoError(t, err)
+	conn := v.Export().(*Connection)
+	samples := moveToVUContext(t, rt)
+
+	wait := local.Emit(&event.Event{Type: event.IterEnd, Data: event.IterData{}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, wait(ctx))
+
+	assert.True(t, conn.nc.IsConnected())
+	assert.NotContains(t, collectSamples(samples), "nats_leaked_resources")
+}
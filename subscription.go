@@ -0,0 +1,415 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/nats-io/nats.go"
+	"go.k6.io/k6/js/modules"
+)
+
+const (
+	// OverflowDrop discards deliveries that do not fit in the handler queue.
+	OverflowDrop = "drop"
+	// OverflowBlock makes the NATS delivery goroutine wait for queue space.
+	OverflowBlock = "block"
+
+	defaultHandlerQueueSize = 1024
+)
+
+// SubscribeOptions configures how messages are handed to a JS handler.
+type SubscribeOptions struct {
+	QueueSize int    `js:"queueSize"`
+	Overflow  string `js:"overflow"`
+}
+
+// handlerDispatcher queues messages received on NATS delivery goroutines and
+// runs the JS handler for them on the VU's event loop. While the dispatcher is
+// running it keeps a callback registered, so the iteration does not end until
+// the subscription is closed.
+type handlerDispatcher struct {
+	vu      modules.VU
+	handler goja.Callable
+	metrics *NatsMetrics
+	sub     *nats.Subscription
+
+	queue   chan *nats.Msg
+	block   bool
+	dropped atomic.Uint64
+
+	// Set by Subscription.drain, so that the handler still runs for the
+	// messages queued when nats.go closes the subscription
+	draining atomic.Bool
+
+	// Counters for Subscription.stats
+	delivered      atomic.Uint64
+	deliveredBytes atomic.Uint64
+	queuedBytes    atomic.Int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHandlerDispatcher(
+	vu modules.VU, handler goja.Callable, opts SubscribeOptions, metrics *NatsMetrics,
+) *handlerDispatcher {
+	size := opts.QueueSize
+	if size <= 0 {
+		size = defaultHandlerQueueSize
+	}
+
+	return &handlerDispatcher{
+		vu:      vu,
+		handler: handler,
+		metrics: metrics,
+		queue:   make(chan *nats.Msg, size),
+		block:   opts.Overflow == OverflowBlock,
+		done:    make(chan struct{}),
+	}
+}
+
+// deliver is called from NATS delivery goroutines.
+func (d *handlerDispatcher) deliver(msg *nats.Msg) {
+	d.delivered.Add(1)
+	d.deliveredBytes.Add(uint64(len(msg.Data)))
+
+	if d.block {
+		d.queuedBytes.Add(int64(len(msg.Data)))
+		select {
+		case d.queue <- msg:
+		case <-d.done:
+			d.queuedBytes.Add(-int64(len(msg.Data)))
+		case <-d.vu.Context().Done():
+			d.queuedBytes.Add(-int64(len(msg.Data)))
+		}
+		return
+	}
+
+	d.queuedBytes.Add(int64(len(msg.Data)))
+	select {
+	case d.queue <- msg:
+	default:
+		d.queuedBytes.Add(-int64(len(msg.Data)))
+		d.dropped.Add(1)
+		d.metrics.RecordMessageDropped()
+	}
+}
+
+// Dropped returns the number of deliveries discarded because the queue was full.
+func (d *handlerDispatcher) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// start must be called on the event loop, once sub is known.
+func (d *handlerDispatcher) start(sub *nats.Subscription) {
+	d.sub = sub
+	enqueue := d.vu.RegisterCallback()
+
+	go func() {
+		for {
+			select {
+			case msg := <-d.queue:
+				next := make(chan func(func() error), 1)
+				enqueue(func() error {
+					err := d.handle(msg)
+					// Handle whatever else piled up in the meantime in the same turn
+					for n := len(d.queue); err == nil && n > 0; n-- {
+						err = d.handle(<-d.queue)
+					}
+
+					select {
+					case <-d.done:
+						if err == nil {
+							err = d.finish()
+						}
+						next <- nil
+					default:
+						next <- d.vu.RegisterCallback()
+					}
+					return err
+				})
+
+				select {
+				case enqueue = <-next:
+				case <-d.vu.Context().Done():
+					return
+				}
+				if enqueue == nil {
+					return
+				}
+			case <-d.done:
+				enqueue(d.finish)
+				return
+			case <-d.vu.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func (d *handlerDispatcher) handle(msg *nats.Msg) error {
+	d.queuedBytes.Add(-int64(len(msg.Data)))
+
+	// Skip messages still queued when the subscription was closed, unless
+	// it was drained
+	if !d.sub.IsValid() && !d.draining.Load() {
+		return nil
+	}
+
+	_, err := d.handler(goja.Undefined(), d.vu.Runtime().ToValue(newMessage(d.vu, msg, d.metrics)))
+	return err
+}
+
+// finish handles the messages left in the queue once the subscription is
+// closed. nats.go delivers nothing after that, so the queue no longer grows.
+// It must be called on the event loop.
+func (d *handlerDispatcher) finish() error {
+	var err error
+	for err == nil && len(d.queue) > 0 {
+		err = d.handle(<-d.queue)
+	}
+	return err
+}
+
+// stop releases the event loop once the queued messages are handled. It is safe to call more than once.
+func (d *handlerDispatcher) stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+	})
+}
+
+// Subscription is the handle on a subscription with a handler, returned by
+// conn.subscribe and js.pushSubscribe. Messages are counted when nats.go
+// hands them to the handler queue; those waiting in either nats.go's buffer
+// or the handler queue are pending.
+type Subscription struct {
+	Subject string `js:"subject"`
+	Queue   string `js:"queue"`
+
+	vu         modules.VU
+	sub        *nats.Subscription
+	dispatcher *handlerDispatcher
+}
+
+// SubscriptionStats are the counters of a Subscription.
+type SubscriptionStats struct {
+	MessagesDelivered uint64 `js:"messagesDelivered"`
+	BytesDelivered    uint64 `js:"bytesDelivered"`
+	PendingMessages   int    `js:"pendingMessages"`
+	PendingBytes      int    `js:"pendingBytes"`
+	Dropped           uint64 `js:"dropped"`
+	MaxPending        int    `js:"maxPending"`
+	Active            bool   `js:"active"`
+}
+
+func newSubscription(vu modules.VU, sub *nats.Subscription, dispatcher *handlerDispatcher) *Subscription {
+	return &Subscription{
+		Subject:    sub.Subject,
+		Queue:      sub.Queue,
+		vu:         vu,
+		sub:        sub,
+		dispatcher: dispatcher,
+	}
+}
+
+// Unsubscribe closes the subscription. Messages not handled yet are
+// skipped, also when it was being drained.
+func (s *Subscription) Unsubscribe() (err error) {
+	defer convertError(s.vu, &err)
+
+	s.dispatcher.draining.Store(false)
+	if err := s.sub.Unsubscribe(); err != nil {
+		return NewNatsError(1010, "unsubscribe failed", err)
+	}
+	return nil
+}
+
+// Drain unsubscribes after the handler ran for the messages received so far.
+func (s *Subscription) Drain() (err error) {
+	defer convertError(s.vu, &err)
+
+	s.dispatcher.draining.Store(true)
+	if err := s.sub.Drain(); err != nil {
+		s.dispatcher.draining.Store(false)
+		return NewNatsError(1012, "drain failed", err)
+	}
+	return nil
+}
+
+// SetPendingLimits sets how many messages and bytes nats.go buffers for the
+// subscription before it drops messages and reports a slow consumer. -1
+// removes a limit. The defaults are 512k messages and 64MB.
+func (s *Subscription) SetPendingLimits(msgs, bytes int) (err error) {
+	defer convertError(s.vu, &err)
+
+	if msgs == 0 || bytes == 0 {
+		return NewNatsError(1003, "pending limits must be positive or -1", nil)
+	}
+	if err := s.sub.SetPendingLimits(msgs, bytes); err != nil {
+		return NewNatsError(1010, "failed to set pending limits", err)
+	}
+	return nil
+}
+
+// Stats returns the counters of the subscription, which remain available
+// once it is closed.
+func (s *Subscription) Stats() *SubscriptionStats {
+	stats := &SubscriptionStats{
+		MessagesDelivered: s.dispatcher.delivered.Load(),
+		BytesDelivered:    s.dispatcher.deliveredBytes.Load(),
+		Dropped:           s.dispatcher.Dropped(),
+		Active:            s.sub.IsValid(),
+	}
+	if !stats.Active {
+		return stats
+	}
+
+	queued := len(s.dispatcher.queue)
+	stats.PendingMessages, stats.PendingBytes = queued, int(s.dispatcher.queuedBytes.Load())
+	if msgs, bytes, err := s.sub.Pending(); err == nil {
+		stats.PendingMessages += msgs
+		stats.PendingBytes += bytes
+	}
+	if dropped, err := s.sub.Dropped(); err == nil {
+		stats.Dropped += uint64(dropped)
+	}
+	if maxMsgs, _, err := s.sub.MaxPending(); err == nil {
+		stats.MaxPending = maxMsgs
+	}
+	return stats
+}
+
+// Pending returns the number of messages waiting for the handler.
+func (s *Subscription) Pending() int {
+	return s.Stats().PendingMessages
+}
+
+// Delivered returns the number of messages received.
+func (s *Subscription) Delivered() uint64 {
+	return s.Stats().MessagesDelivered
+}
+
+// Dropped returns the number of messages dropped because the handler queue
+// or nats.go's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.Stats().Dropped
+}
+
+// MaxPending returns the highest number of messages nats.go buffered at once.
+func (s *Subscription) MaxPending() int {
+	return s.Stats().MaxPending
+}
+
+// IsValid reports whether the subscription is still open.
+func (s *Subscription) IsValid() bool {
+	return s.sub.IsValid()
+}
+
+func parseSubscribeOptions(opts goja.Value) (SubscribeOptions, error) {
+	var subOpts SubscribeOptions
+	if err := parseJSOptions(opts, &subOpts); err != nil {
+		return subOpts, NewNatsError(1003, "failed to parse subscribe options", err)
+	}
+
+	if err := ValidateSubscribeOptions(subOpts); err != nil {
+		return subOpts, NewNatsError(1003, "invalid subscribe options", err)
+	}
+
+	return subOpts, nil
+}
+
+// SyncSubscription is a subscription without handler that the script reads
+// from with nextMsg, e.g. to wait for a specific reply within an iteration.
+// Unlike handler subscriptions it does not keep the iteration alive.
+type SyncSubscription struct {
+	Subject string `js:"subject"`
+	Queue   string `js:"queue"`
+
+	vu        modules.VU
+	sub       *nats.Subscription
+	metrics   *NatsMetrics
+	resources *vuResources
+}
+
+// NextMsg waits for the next message, at most for timeout, given in
+// milliseconds or as a duration string (30s by default).
+func (s *SyncSubscription) NextMsg(timeoutArg goja.Value) (_ *Message, err error) {
+	defer convertError(s.vu, &err)
+	// The subscription closes itself after the last message of autoUnsubscribe
+	defer s.checkClosed()
+
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	// Bound the wait by the VU context so it is abandoned when the VU stops
+	ctx, cancel := context.WithTimeout(s.vu.Context(), timeout)
+	defer cancel()
+
+	msg, err := s.sub.NextMsgWithContext(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, NewNatsError(1006, "no message received in time", nats.ErrTimeout)
+		}
+		return nil, NewNatsError(1010, "failed to receive message", err)
+	}
+	s.metrics.RecordMessageReceived(s.Subject, int64(len(msg.Data)), 0)
+
+	return newMessage(s.vu, msg, s.metrics), nil
+}
+
+// Pending returns the number of messages received but not read yet.
+func (s *SyncSubscription) Pending() (_ int, err error) {
+	defer convertError(s.vu, &err)
+
+	msgs, _, err := s.sub.Pending()
+	if err != nil {
+		return 0, NewNatsError(1010, "failed to get pending messages", err)
+	}
+	return msgs, nil
+}
+
+func (s *SyncSubscription) Unsubscribe() (err error) {
+	defer convertError(s.vu, &err)
+	defer s.checkClosed()
+
+	if err := s.sub.Unsubscribe(); err != nil {
+		return NewNatsError(1010, "unsubscribe failed", err)
+	}
+	return nil
+}
+
+// AutoUnsubscribe closes the subscription once maxMsgs messages were received,
+// counting those received already.
+func (s *SyncSubscription) AutoUnsubscribe(maxMsgs int) (err error) {
+	defer convertError(s.vu, &err)
+
+	if maxMsgs <= 0 {
+		return NewNatsError(1003, "max must be positive", nil)
+	}
+	if err := s.sub.AutoUnsubscribe(maxMsgs); err != nil {
+		return NewNatsError(1010, "auto unsubscribe failed", err)
+	}
+	return nil
+}
+
+// IsValid reports whether the subscription is still open.
+func (s *SyncSubscription) IsValid() bool {
+	return s.sub.IsValid()
+}
+
+// checkClosed does the bookkeeping nats.go leaves to the closed handler of
+// async subscriptions once the subscription is closed.
+func (s *SyncSubscription) checkClosed() {
+	if !s.sub.IsValid() {
+		s.resources.closeSub(s.sub)
+	}
+}
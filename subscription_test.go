@@ -0,0 +1,258 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/metrics"
+)
+
+func TestSubscribeHandlerRunsOnEventLoop(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		const received = [];
+		const sub = conn.subscribe("events.>", "", (msg) => {
+			received.push(msg.subject);
+			if (received.length === 3) {
+				sub.unsubscribe();
+				conn.close();
+			}
+		});
+		for (let i = 0; i < 3; i++) {
+			conn.publish("events." + i, "payload");
+		}
+	`)
+	require.NoError(t, err)
+
+	received := rt.VU.Runtime().Get("received").Export()
+	assert.Equal(t, []any{"events.0", "events.1", "events.2"}, received)
+}
+
+func TestSubscribeOverflowDrop(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+	require.NoError(t, rt.VU.Runtime().Set("sleep", func(ms int) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+		let handled = 0;
+		const sub = conn.subscribe("burst", "", () => {
+			handled++;
+			sub.unsubscribe();
+			conn.close();
+		}, { queueSize: 1, overflow: "drop" });
+		for (let i = 0; i < 10; i++) {
+			conn.publish("burst", "payload");
+		}
+		conn.flush();
+		// Keep the event loop busy so deliveries pile up in the queue
+		sleep(200);
+	`)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), rt.VU.Runtime().Get("handled").ToInteger())
+	// One message may already have left the queue when the burst arrives
+	assert.GreaterOrEqual(t, collectSamples(samples)["nats_msgs_dropped"], 8.0)
+}
+
+func TestSubscriptionHandle(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+	require.NoError(t, rt.VU.Runtime().Set("sleep", func(ms int) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+
+		let stats;
+		const sub = conn.subscribe("counted", "", () => {
+			if (sub.delivered() === 3) {
+				stats = sub.stats();
+				sub.drain();
+			}
+		});
+		for (let i = 0; i < 3; i++) {
+			conn.publish("counted", "abcd");
+		}
+
+		// The handler blocks nats.go's delivery, so its buffer overflows
+		const slow = conn.subscribe("slow", "", () => {}, { queueSize: 1, overflow: "block" });
+		slow.setPendingLimits(2, -1);
+		for (let i = 0; i < 20; i++) {
+			conn.publish("slow", "payload");
+		}
+		conn.flush();
+		sleep(200);
+		const slowStats = slow.stats();
+		const slowSubject = slow.subject;
+		slow.unsubscribe();
+
+		let invalidLimits;
+		try {
+			slow.setPendingLimits(0, 0);
+		} catch (e) {
+			invalidLimits = e.code;
+		}
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	stats := runtime.Get("stats").Export().(*SubscriptionStats)
+	assert.Equal(t, uint64(3), stats.MessagesDelivered)
+	assert.Equal(t, uint64(12), stats.BytesDelivered)
+	assert.True(t, stats.Active)
+
+	slowStats := runtime.Get("slowStats").Export().(*SubscriptionStats)
+	assert.Positive(t, slowStats.MaxPending)
+	assert.Positive(t, slowStats.Dropped)
+	assert.Positive(t, slowStats.PendingMessages)
+	assert.Equal(t, "slow", runtime.Get("slowSubject").String())
+	assert.Equal(t, int64(1003), runtime.Get("invalidLimits").ToInteger())
+
+	slowConsumers := 0.0
+	assert.Eventually(t, func() bool {
+		slowConsumers += collectSamples(samples)["nats_slow_consumers"]
+		return slowConsumers > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	conn := runtime.Get("conn").Export().(*Connection)
+	require.NoError(t, conn.Close())
+}
+
+func TestSubscriptionDrainSlowHandler(t *testing.T) {
+	s := runTestServer(t)
+	rt, _ := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+	require.NoError(t, rt.VU.Runtime().Set("sleep", func(ms int) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}))
+
+	_, err := rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+
+		let handled = 0;
+		const sub = conn.subscribe("drained", "", () => {
+			handled++;
+			sleep(20);
+		});
+		for (let i = 0; i < 50; i++) {
+			conn.publish("drained", "payload");
+		}
+		conn.flush();
+		sub.drain();
+
+		// Messages queued when the script unsubscribes are skipped
+		let skipped = 0;
+		const closed = conn.subscribe("skipped", "", () => {
+			skipped++;
+			closed.unsubscribe();
+			sleep(20);
+		});
+		for (let i = 0; i < 10; i++) {
+			conn.publish("skipped", "payload");
+		}
+		conn.flush();
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, int64(50), runtime.Get("handled").ToInteger())
+	assert.Equal(t, int64(1), runtime.Get("skipped").ToInteger())
+
+	conn := runtime.Get("conn").Export().(*Connection)
+	require.NoError(t, conn.Close())
+}
+
+func TestSubscribeSync(t *testing.T) {
+	s := runTestServer(t)
+	rt, samples := newTestRuntime(t)
+	require.NoError(t, rt.VU.Runtime().Set("serverURL", s.ClientURL()))
+
+	// A worker that answers on a subject derived from the job id
+	worker, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer worker.Close()
+	_, err = worker.Subscribe("jobs", func(msg *nats.Msg) {
+		_ = worker.Publish("jobs.done."+string(msg.Data), []byte("done"))
+	})
+	require.NoError(t, err)
+	require.NoError(t, worker.Flush())
+
+	_, err = rt.RunOnEventLoop(`
+		const conn = nats.connect({ urls: [serverURL] });
+
+		const done = conn.subscribeSync("jobs.done.42");
+		conn.publish("jobs", "42");
+		const reply = done.nextMsg("2s");
+		const replySubject = reply.subject;
+		done.unsubscribe();
+		const closedAfterUnsubscribe = !done.isValid();
+
+		const burst = conn.subscribeSync("burst");
+		burst.autoUnsubscribe(3);
+		for (let i = 0; i < 5; i++) {
+			conn.publish("burst", String(i));
+		}
+		conn.flush();
+		const first = burst.nextMsg(1000).text();
+		const pending = burst.pending();
+		burst.nextMsg(1000);
+		burst.nextMsg(1000);
+		const closedAfterMax = !burst.isValid();
+
+		const idle = conn.subscribeSync("idle", "workers");
+		const idleQueue = idle.queue;
+		let timeout;
+		try {
+			idle.nextMsg("50ms");
+		} catch (e) {
+			timeout = e.code === 1006 && e.isTimeout;
+		}
+		conn.close();
+	`)
+	require.NoError(t, err)
+
+	runtime := rt.VU.Runtime()
+	assert.Equal(t, "jobs.done.42", runtime.Get("replySubject").String())
+	assert.True(t, runtime.Get("closedAfterUnsubscribe").ToBoolean())
+	assert.Equal(t, "0", runtime.Get("first").String())
+	assert.Equal(t, int64(2), runtime.Get("pending").ToInteger())
+	assert.True(t, runtime.Get("closedAfterMax").ToBoolean())
+	assert.Equal(t, "workers", runtime.Get("idleQueue").String())
+	assert.True(t, runtime.Get("timeout").ToBoolean())
+
+	// Unsubscribed, auto-unsubscribed and closed with their connection
+	conn := runtime.Get("conn").Export().(*Connection)
+	active := -1.0
+	assert.Eventually(t, func() bool {
+		for _, container := range metrics.GetBufferedSamples(samples) {
+			for _, sample := range container.GetSamples() {
+				if sample.Metric.Name == "nats_subscriptions_active" {
+					active = sample.Value
+				}
+			}
+		}
+		conn.resources.mu.Lock()
+		defer conn.resources.mu.Unlock()
+		return active == 0 && len(conn.resources.subs) == 0
+	}, 2*time.Second, 10*time.Millisecond, "active subscriptions: %v", active)
+}
+
+func TestValidateSubscribeOptions(t *testing.T) {
+	assert.NoError(t, ValidateSubscribeOptions(SubscribeOptions{}))
+	assert.NoError(t, ValidateSubscribeOptions(SubscribeOptions{QueueSize: 10, Overflow: OverflowBlock}))
+	assert.Error(t, ValidateSubscribeOptions(SubscribeOptions{QueueSize: -1}))
+	assert.Error(t, ValidateSubscribeOptions(SubscribeOptions{Overflow: "wait"}))
+}
@@ -1,4 +1,4 @@
-import nats from "k6/Pondigo/nats";
+import nats from "k6/x/nats";
 
 export const options = {
   vus: 1,
@@ -9,9 +9,9 @@ export default function () {
   // Test connection
   const conn = nats.connect({
     urls: ["nats://localhost:4222"],
-    reconnectWait: 2,
+    reconnectWait: "2s",
     maxReconnects: 10,
-    pingInterval: 60,
+    pingInterval: "1m",
     maxPingsOut: 2,
   });
 
@@ -23,11 +23,11 @@ export default function () {
   console.log("Connected to NATS successfully");
 
   // Test basic publish
-  const publishResult = conn.publish("test.subject", "Hello from k6!");
-  if (publishResult) {
+  try {
+    conn.publish("test.subject", "Hello from k6!");
     console.log("Published message successfully");
-  } else {
-    console.log("Failed to publish message");
+  } catch (error) {
+    console.log("Failed to publish message:", error.message);
   }
 
   // Test JetStream
@@ -49,7 +49,7 @@ export default function () {
     replicas: 1,
     maxBytes: 1048576, // 1MB
     maxMsgs: 1000,
-    maxAge: 3600, // 1 hour
+    maxAge: "1h",
     discard: "old",
   });
 
@@ -90,7 +90,7 @@ export default function () {
     durable: "TEST_CONSUMER",
     deliverPolicy: "all",
     ackPolicy: "explicit",
-    ackWait: 30,
+    ackWait: "30s",
     maxDeliver: 3,
     replayPolicy: "instant",
   });
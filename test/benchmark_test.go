@@ -4,6 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib/types"
+
 	natslib "github.com/pondigo/xk6-nats"
 )
 
@@ -11,10 +14,10 @@ func BenchmarkConnectionValidation(b *testing.B) {
 	opts := natslib.ConnectionOptions{
 		URLs:           []string{"nats://localhost:4222"},
 		MaxReconnects:  10,
-		ReconnectWait:  2,
-		PingInterval:   60,
+		ReconnectWait:  types.Duration(2 * time.Second),
+		PingInterval:   types.Duration(60 * time.Second),
 		MaxPingsOut:    2,
-		AllowReconnect: true,
+		AllowReconnect: boolPtr(true),
 		TLS: &natslib.TLSOptions{
 			CertFile: "cert.pem",
 			KeyFile:  "key.pem",
@@ -38,7 +41,7 @@ func BenchmarkStreamConfigValidation(b *testing.B) {
 		Replicas:  3,
 		MaxBytes:  1024 * 1024 * 1024, // 1GB
 		MaxMsgs:   1000000,
-		MaxAge:    86400, // 24 hours
+		MaxAge:    types.Duration(86400 * time.Second), // 24 hours
 	}
 
 	b.ResetTimer()
@@ -53,9 +56,9 @@ func BenchmarkConsumerConfigValidation(b *testing.B) {
 		Durable:       "TEST_CONSUMER",
 		DeliverPolicy: "all",
 		AckPolicy:     "explicit",
-		AckWait:       30,
+		AckWait:       types.Duration(30 * time.Second),
 		MaxDeliver:    3,
-		BackOff:       []int{1, 2, 4, 8, 16},
+		BackOff:       []types.Duration{types.Duration(1 * time.Second), types.Duration(2 * time.Second), types.Duration(4 * time.Second), types.Duration(8 * time.Second), types.Duration(16 * time.Second)},
 		FilterSubject: "test.>",
 		ReplayPolicy:  "instant",
 		SampleFreq:    "100%",
@@ -93,9 +96,7 @@ func BenchmarkParseTimestamp(b *testing.B) {
 }
 
 func BenchmarkMetricsCreation(b *testing.B) {
-	vu := &MockVU{
-		ctx: nil,
-	}
+	vu := modulestest.NewRuntime(b).VU
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -104,9 +105,7 @@ func BenchmarkMetricsCreation(b *testing.B) {
 }
 
 func BenchmarkMetricsRecording(b *testing.B) {
-	vu := &MockVU{
-		ctx: nil,
-	}
+	vu := modulestest.NewRuntime(b).VU
 
 	metrics, _ := natslib.NewNatsMetrics(vu)
 
@@ -114,7 +113,7 @@ func BenchmarkMetricsRecording(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		switch i % 10 {
 		case 0:
-			metrics.RecordConnectionEstablished()
+			metrics.RecordConnectionEstablished("nats://localhost:4222", 3*time.Millisecond)
 		case 1:
 			metrics.RecordMessagePublished("test.subject", 1024, time.Millisecond)
 		case 2:
@@ -143,10 +142,10 @@ func BenchmarkComplexValidation(b *testing.B) {
 	connOpts := natslib.ConnectionOptions{
 		URLs:           []string{"nats://server1:4222", "nats://server2:4222", "nats://server3:4222"},
 		MaxReconnects:  100,
-		ReconnectWait:  5,
-		PingInterval:   30,
+		ReconnectWait:  types.Duration(5 * time.Second),
+		PingInterval:   types.Duration(30 * time.Second),
 		MaxPingsOut:    5,
-		AllowReconnect: true,
+		AllowReconnect: boolPtr(true),
 		User:           "testuser",
 		Password:       "testpass",
 		Token:          "testtoken",
@@ -165,7 +164,7 @@ func BenchmarkComplexValidation(b *testing.B) {
 		Retention: "limits",
 		MaxBytes:  10 * 1024 * 1024 * 1024, // 10GB
 		MaxMsgs:   50000000,
-		MaxAge:    7 * 86400, // 7 days
+		MaxAge:    types.Duration(7 * 86400 * time.Second), // 7 days
 		Replicas:  5,
 		Discard:   "old",
 		Storage:   "file",
@@ -178,9 +177,9 @@ func BenchmarkComplexValidation(b *testing.B) {
 		DeliverPolicy: "by_start_sequence",
 		OptStartSeq:   1000000,
 		AckPolicy:     "explicit",
-		AckWait:       60,
+		AckWait:       types.Duration(60 * time.Second),
 		MaxDeliver:    10,
-		BackOff:       []int{1, 2, 4, 8, 16, 32, 64},
+		BackOff:       []types.Duration{types.Duration(1 * time.Second), types.Duration(2 * time.Second), types.Duration(4 * time.Second), types.Duration(8 * time.Second), types.Duration(16 * time.Second), types.Duration(32 * time.Second), types.Duration(64 * time.Second)},
 		FilterSubject: "events.important.>",
 		ReplayPolicy:  "original",
 		SampleFreq:    "0.1%",
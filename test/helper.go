@@ -7,6 +7,7 @@ import (
 
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/lib/types"
 
 	natslib "github.com/pondigo/xk6-nats"
 )
@@ -40,10 +41,10 @@ func (h *TestHelper) GetValidConnectionOptions() natslib.ConnectionOptions {
 	return natslib.ConnectionOptions{
 		URLs:           []string{nats.DefaultURL},
 		MaxReconnects:  5,
-		ReconnectWait:  2,
-		PingInterval:   60,
+		ReconnectWait:  types.Duration(2 * time.Second),
+		PingInterval:   types.Duration(60 * time.Second),
 		MaxPingsOut:    2,
-		AllowReconnect: true,
+		AllowReconnect: boolPtr(true),
 	}
 }
 
@@ -61,7 +62,7 @@ func (h *TestHelper) GetValidConsumerConfig() natslib.ConsumerConfig {
 	return natslib.ConsumerConfig{
 		Stream:  "TEST_STREAM",
 		Durable: "TEST_CONSUMER",
-		AckWait: 30,
+		AckWait: types.Duration(30 * time.Second),
 	}
 }
 
@@ -264,3 +265,7 @@ func (h *TestHelper) AssertPerformance(result PerformanceTestResult, minThroughp
 	require.LessOrEqualf(h.t, result.AvgLatency, maxLatency,
 		"Average latency %v exceeds maximum %v", result.AvgLatency, maxLatency)
 }
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -1,13 +1,14 @@
 package test
 
 import (
-	"context"
 	"testing"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib/types"
 
 	natslib "github.com/pondigo/xk6-nats"
 )
@@ -20,10 +21,10 @@ func TestConnectionLifecycle(t *testing.T) {
 	opts := natslib.ConnectionOptions{
 		URLs:           []string{nats.DefaultURL},
 		MaxReconnects:  5,
-		ReconnectWait:  2,
-		PingInterval:   60,
+		ReconnectWait:  types.Duration(2 * time.Second),
+		PingInterval:   types.Duration(60 * time.Second),
 		MaxPingsOut:    2,
-		AllowReconnect: true,
+		AllowReconnect: boolPtr(true),
 	}
 
 	err := natslib.ValidateConnectionOptions(opts)
@@ -50,7 +51,7 @@ func TestStreamConfigConversion(t *testing.T) {
 				Replicas:  1,
 				MaxBytes:  1024 * 1024,
 				MaxMsgs:   1000,
-				MaxAge:    3600,
+				MaxAge:    types.Duration(3600 * time.Second),
 			},
 			expected: &nats.StreamConfig{
 				Name:      "TEST_STREAM",
@@ -125,7 +126,7 @@ func TestConsumerConfigConversion(t *testing.T) {
 				Durable:       "TEST_CONSUMER",
 				DeliverPolicy: "all",
 				AckPolicy:     "explicit",
-				AckWait:       30,
+				AckWait:       types.Duration(30 * time.Second),
 				MaxDeliver:    3,
 				ReplayPolicy:  "instant",
 			},
@@ -181,9 +182,7 @@ func TestConsumerConfigConversion(t *testing.T) {
 }
 
 func TestMetricsCreation(t *testing.T) {
-	vu := &MockVU{
-		ctx: context.Background(),
-	}
+	vu := modulestest.NewRuntime(t).VU
 
 	// Test metrics creation
 	metrics, err := natslib.NewNatsMetrics(vu)
@@ -193,13 +192,16 @@ func TestMetricsCreation(t *testing.T) {
 	// Test registry
 	registry := metrics.Registry()
 	assert.NotNil(t, registry)
+	assert.NotNil(t, registry.Get("nats_msgs_published"))
+	assert.NotNil(t, registry.Get("nats_request_duration"))
 
-	// Test placeholder methods (they should not panic)
+	// Recording outside of the VU context should not panic
 	assert.NotPanics(t, func() {
-		metrics.RecordConnectionEstablished()
+		metrics.RecordConnectionEstablished("nats://localhost:4222", 3*time.Millisecond)
 		metrics.RecordConnectionClosed()
-		metrics.RecordConnectionFailed()
-		metrics.RecordReconnect()
+		metrics.RecordConnectionFailed("nats://localhost:4222")
+		metrics.RecordDisconnect("nats://localhost:4222")
+		metrics.RecordReconnect("nats://localhost:4222", 50*time.Millisecond)
 		metrics.RecordMessagePublished("test.subject", 100, time.Millisecond)
 		metrics.RecordMessageReceived("test.subject", 100, time.Millisecond)
 		metrics.RecordPublishError()
@@ -214,6 +216,7 @@ func TestMetricsCreation(t *testing.T) {
 		metrics.RecordConsumerMessageAcked()
 		metrics.RecordConsumerMessageNacked()
 		metrics.RecordConsumerRedelivery()
+		metrics.RecordLeakedResources("connection", 1)
 	})
 }
 
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.k6.io/k6/lib/types"
 
 	natslib "github.com/pondigo/xk6-nats"
 )
@@ -20,10 +21,10 @@ func TestConnectionOptions(t *testing.T) {
 			opts: natslib.ConnectionOptions{
 				URLs:           []string{"nats://localhost:4222"},
 				MaxReconnects:  10,
-				ReconnectWait:  2,
-				PingInterval:   60,
+				ReconnectWait:  types.Duration(2 * time.Second),
+				PingInterval:   types.Duration(60 * time.Second),
 				MaxPingsOut:    2,
-				AllowReconnect: true,
+				AllowReconnect: boolPtr(true),
 			},
 			wantErr: false,
 		},
@@ -42,14 +43,14 @@ func TestConnectionOptions(t *testing.T) {
 		{
 			name: "negative reconnectWait",
 			opts: natslib.ConnectionOptions{
-				ReconnectWait: -1,
+				ReconnectWait: types.Duration(-time.Second),
 			},
 			wantErr: true,
 		},
 		{
 			name: "negative pingInterval",
 			opts: natslib.ConnectionOptions{
-				PingInterval: -1,
+				PingInterval: types.Duration(-time.Second),
 			},
 			wantErr: true,
 		},
@@ -174,7 +175,7 @@ func TestStreamConfig(t *testing.T) {
 			config: natslib.StreamConfig{
 				Name:     "TEST_STREAM",
 				Subjects: []string{"test.>"},
-				MaxAge:   -1,
+				MaxAge:   types.Duration(-time.Second),
 			},
 			wantErr: true,
 		},
@@ -253,7 +254,7 @@ func TestConsumerConfig(t *testing.T) {
 			config: natslib.ConsumerConfig{
 				Stream:     "TEST_STREAM",
 				Durable:    "TEST_CONSUMER",
-				AckWait:    30,
+				AckWait:    types.Duration(30 * time.Second),
 				MaxDeliver: 3,
 			},
 			wantErr: false,
@@ -262,7 +263,7 @@ func TestConsumerConfig(t *testing.T) {
 			name: "empty stream",
 			config: natslib.ConsumerConfig{
 				Durable: "TEST_CONSUMER",
-				AckWait: 30,
+				AckWait: types.Duration(30 * time.Second),
 			},
 			wantErr: true,
 		},
@@ -270,7 +271,7 @@ func TestConsumerConfig(t *testing.T) {
 			name: "negative ackWait",
 			config: natslib.ConsumerConfig{
 				Stream:  "TEST_STREAM",
-				AckWait: -1,
+				AckWait: types.Duration(-time.Second),
 			},
 			wantErr: true,
 		},
@@ -286,7 +287,7 @@ func TestConsumerConfig(t *testing.T) {
 			name: "negative backoff",
 			config: natslib.ConsumerConfig{
 				Stream:  "TEST_STREAM",
-				BackOff: []int{-1, 2, 3},
+				BackOff: []types.Duration{types.Duration(-time.Second), types.Duration(2 * time.Second), types.Duration(3 * time.Second)},
 			},
 			wantErr: true,
 		},